@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// activityTopCollections is how many collections the report lists
+const activityTopCollections = 5
+
+// didSample is a tracked DID's event count and the hash that decides
+// whether it stays in the sample
+type didSample struct {
+	hash   uint64
+	events uint64
+}
+
+// collectionActivity is one collection's sketch of events per DID. DIDs
+// are sampled by hash: a DID is tracked if its hash is below threshold, and
+// each time more than the cap are tracked the threshold halves, dropping
+// about half of them. Because a DID is either tracked for every one of its
+// events or not at all, the tracked counts are an unbiased sample of the
+// events-per-DID distribution.
+type collectionActivity struct {
+	events    uint64
+	threshold uint64
+	dids      map[string]didSample
+}
+
+// activityTracker records, per collection and interval, how many events
+// each DID sent
+type activityTracker struct {
+	mu          sync.Mutex
+	seed        maphash.Seed
+	maxDIDs     int
+	collections map[string]*collectionActivity
+}
+
+func newActivityTracker(maxDIDs int) *activityTracker {
+	return &activityTracker{
+		seed:        maphash.MakeSeed(),
+		maxDIDs:     maxDIDs,
+		collections: make(map[string]*collectionActivity),
+	}
+}
+
+// Observe counts a commit by did to collection
+func (a *activityTracker) Observe(collection, did string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.collections[collection]
+	if !ok {
+		c = &collectionActivity{threshold: math.MaxUint64, dids: make(map[string]didSample)}
+		a.collections[collection] = c
+	}
+	c.events++
+
+	s, ok := c.dids[did]
+	if !ok {
+		s.hash = maphash.String(a.seed, did)
+		if s.hash >= c.threshold {
+			return
+		}
+	}
+	s.events++
+	c.dids[did] = s
+	c.trim(a.maxDIDs)
+}
+
+// Resize changes how many DIDs each collection samples, dropping DIDs from
+// collections that now sample too many
+func (a *activityTracker) Resize(maxDIDs int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxDIDs = maxDIDs
+	for _, c := range a.collections {
+		c.trim(maxDIDs)
+	}
+}
+
+// trim halves the sampling threshold until at most maxDIDs are tracked
+func (c *collectionActivity) trim(maxDIDs int) {
+	for len(c.dids) > maxDIDs {
+		c.threshold /= 2
+		for k, v := range c.dids {
+			if v.hash >= c.threshold {
+				delete(c.dids, k)
+			}
+		}
+	}
+}
+
+// take returns the interval's sketches and starts a new interval
+func (a *activityTracker) take() map[string]*collectionActivity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	collections := a.collections
+	a.collections = make(map[string]*collectionActivity)
+	return collections
+}
+
+// reportActivity returns a stats reporter printing the events-per-DID
+// distribution of the busiest collections over the interval
+func reportActivity(a *activityTracker) func(w io.Writer) {
+	return func(w io.Writer) {
+		collections := a.take()
+		keys := make([]string, 0, len(collections))
+		for key := range collections {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return collections[keys[i]].events > collections[keys[j]].events })
+
+		fmt.Fprintln(w, "Events per DID:")
+		for _, key := range keys[:min(len(keys), activityTopCollections)] {
+			c := collections[key]
+			counts := make([]int, 0, len(c.dids))
+			for _, s := range c.dids {
+				counts = append(counts, int(s.events))
+			}
+			slices.Sort(counts)
+
+			// Scale the sampled DID count back up by the sampling rate
+			activeDIDs := float64(len(counts)) / (float64(c.threshold) / math.MaxUint64)
+			estimate := ""
+			if c.threshold != math.MaxUint64 {
+				estimate = "~"
+			}
+			fmt.Fprintf(w, "  %s: %d events from %s%.0f DIDs, median %d, p99 %d, max %d\n",
+				key, c.events, estimate, activeDIDs,
+				percentile(counts, 50), percentile(counts, 99), slices.Max(append(counts, 0)))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bandwidthSamples is how many message sizes are kept per collection each
+// interval for the percentiles, chosen by reservoir sampling
+const bandwidthSamples = 1024
+
+// bandwidthTopCollections is how many collections the report lists
+const bandwidthTopCollections = 5
+
+// sizeStats accumulates message sizes for one collection over an interval
+type sizeStats struct {
+	messages uint64
+	bytes    uint64
+	samples  []int
+}
+
+// bandwidthTracker records the size of every message read, grouped by
+// collection (or event kind for identity and account events)
+type bandwidthTracker struct {
+	mu          sync.Mutex
+	collections map[string]*sizeStats
+	totalBytes  uint64
+}
+
+func newBandwidthTracker() *bandwidthTracker {
+	return &bandwidthTracker{collections: make(map[string]*sizeStats)}
+}
+
+// Observe records a message of size bytes under key
+func (b *bandwidthTracker) Observe(key string, size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.collections[key]
+	if !ok {
+		s = &sizeStats{}
+		b.collections[key] = s
+	}
+	s.messages++
+	s.bytes += uint64(size)
+	b.totalBytes += uint64(size)
+	if len(s.samples) < bandwidthSamples {
+		s.samples = append(s.samples, size)
+	} else if i := rand.Uint64N(s.messages); i < bandwidthSamples {
+		s.samples[i] = size
+	}
+}
+
+// take returns the interval's stats and starts a new interval
+func (b *bandwidthTracker) take() (map[string]*sizeStats, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	collections := b.collections
+	b.collections = make(map[string]*sizeStats)
+	return collections, b.totalBytes
+}
+
+// bandwidthKey groups a message by its collection, or by kind for
+// non-commit events
+func bandwidthKey(event Event) string {
+	if event.Commit != nil {
+		return event.Commit.Collection
+	}
+	return event.Kind
+}
+
+// reportBandwidth returns a stats reporter printing the interval's
+// bandwidth and the size distribution of the heaviest collections
+func reportBandwidth(b *bandwidthTracker, interval time.Duration) func(w io.Writer) {
+	return func(w io.Writer) {
+		collections, total := b.take()
+
+		var intervalBytes uint64
+		keys := make([]string, 0, len(collections))
+		for key, s := range collections {
+			intervalBytes += s.bytes
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return collections[keys[i]].bytes > collections[keys[j]].bytes })
+
+		fmt.Fprintf(w, "Bandwidth: %.1f KB/s, %s total\n",
+			float64(intervalBytes)/1024/interval.Seconds(), formatBytes(total))
+		for _, key := range keys[:min(len(keys), bandwidthTopCollections)] {
+			s := collections[key]
+			slices.Sort(s.samples)
+			fmt.Fprintf(w, "  %s: %d msgs, %.1f KB (%.0f%%), avg %d B, p50 %d B, p95 %d B, p99 %d B\n",
+				key, s.messages, float64(s.bytes)/1024, percent(s.bytes, intervalBytes),
+				s.bytes/s.messages, percentile(s.samples, 50), percentile(s.samples, 95), percentile(s.samples, 99))
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted values
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// benchDIDs is how many distinct accounts the synthetic events come from
+const benchDIDs = 1000
+
+// benchLangs are cycled through as the synthetic posts' languages
+var benchLangs = [][]string{{"en"}, {"ja"}, {"en", "es"}, {"pt"}, nil}
+
+// syntheticEvent returns the i-th event of a deterministic stream of post
+// creates, with some replies and quotes, shaped like the real firehose
+func syntheticEvent(i int) Event {
+	did := fmt.Sprintf("did:plc:bench%05d", i%benchDIDs)
+	rkey := fmt.Sprintf("3kbench%08d", i)
+	post := map[string]any{
+		"$type":     "app.bsky.feed.post",
+		"text":      fmt.Sprintf("synthetic post %d for sink benchmarking #bench", i),
+		"createdAt": time.UnixMicro(1_700_000_000_000_000 + int64(i)).UTC().Format(time.RFC3339Nano),
+	}
+	if langs := benchLangs[i%len(benchLangs)]; langs != nil {
+		post["langs"] = langs
+	}
+	if i%4 == 1 {
+		root := map[string]string{"uri": "at://" + did + "/app.bsky.feed.post/3kbenchroot", "cid": "bafybench"}
+		post["reply"] = map[string]any{"root": root, "parent": root}
+	}
+	if i%10 == 2 {
+		post["embed"] = map[string]string{"$type": "app.bsky.embed.record"}
+	}
+	record, _ := json.Marshal(post)
+
+	event := Event{
+		Did:    did,
+		TimeUS: clock.Now().UnixMicro(),
+		Kind:   "commit",
+		Commit: &Commit{Rev: rkey, Operation: "create", Collection: "app.bsky.feed.post", RKey: rkey, Record: record},
+	}
+	event.Raw, _ = json.Marshal(event)
+	event.Received = clock.Now()
+	return event
+}
+
+// benchResult is one sink's benchmark outcome
+type benchResult struct {
+	name      string
+	events    int
+	errors    int
+	elapsed   time.Duration
+	latencies []time.Duration
+	close     time.Duration
+}
+
+// benchSinks writes n synthetic events through each configured sink in turn,
+// then closes it, and prints a table of throughput and per-event write
+// latency. Events are generated up front so only sink time is measured.
+func benchSinks(w io.Writer, n int) {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = syntheticEvent(i)
+	}
+
+	var results []benchResult
+	for _, s := range sinks {
+		r := benchResult{name: s.name, events: n, latencies: make([]time.Duration, 0, n)}
+		start := clock.Now()
+		for _, event := range events {
+			before := clock.Now()
+			if err := s.sink.WriteEvent(event); err != nil {
+				r.errors++
+			}
+			r.latencies = append(r.latencies, clock.Now().Sub(before))
+		}
+		r.elapsed = clock.Now().Sub(start)
+
+		before := clock.Now()
+		if err := s.sink.Close(); err != nil {
+			r.errors++
+		}
+		r.close = clock.Now().Sub(before)
+		slices.Sort(r.latencies)
+		results = append(results, r)
+	}
+
+	fmt.Fprintf(w, "%-14s %10s %12s %10s %10s %10s %10s %10s %7s\n",
+		"SINK", "EVENTS", "EVENTS/S", "P50", "P90", "P99", "MAX", "CLOSE", "ERRORS")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-14s %10d %12.0f %10s %10s %10s %10s %10s %7d\n",
+			r.name, r.events, float64(r.events)/r.elapsed.Seconds(),
+			benchPercentile(r.latencies, 50), benchPercentile(r.latencies, 90),
+			benchPercentile(r.latencies, 99), benchPercentile(r.latencies, 100),
+			r.close.Round(time.Microsecond), r.errors)
+	}
+}
+
+// benchPercentile returns the p-th percentile of sorted latencies
+func benchPercentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100].Round(100 * time.Nanosecond)
+}
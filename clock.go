@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// Clock abstracts the time source so time-dependent logic (stats intervals,
+// grouping windows, shutdown timeouts) can be driven deterministically
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker is the part of *time.Ticker the consumer relies on
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// clock is the time source used throughout the consumer. Tests replace it
+// with a manualClock (clock_test.go).
+var clock Clock = realClock{}
+
+// realClock is the default Clock, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
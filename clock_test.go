@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock is a Clock that only moves when Advance is called, for tests
+// of time-dependent behavior
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newManualClock(start time.Time) *manualClock {
+	return &manualClock{now: start}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+func (c *manualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for manualClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &manualTicker{clock: c, period: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing any tickers and After
+// channels that come due. Like time.Ticker, a tick is dropped if the
+// previous one has not been received yet.
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- w.at
+	}
+	c.waiters = pending
+}
+
+type manualTicker struct {
+	clock  *manualClock
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, other := range t.clock.tickers {
+		if other == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// useManualClock swaps in a manualClock for the duration of a test
+func useManualClock(t *testing.T) *manualClock {
+	t.Helper()
+	mc := newManualClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	saved := clock
+	clock = mc
+	t.Cleanup(func() { clock = saved })
+	return mc
+}
+
+func TestManualClockTickerDropsMissedTicks(t *testing.T) {
+	mc := useManualClock(t)
+	ticker := mc.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	mc.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before its period")
+	default:
+	}
+
+	// Three periods pass without the channel being read: only the first
+	// tick is delivered, like time.Ticker
+	mc.Advance(3 * time.Second)
+	if got, want := <-ticker.C(), mc.now.Add(-2500*time.Millisecond); !got.Equal(want) {
+		t.Errorf("tick at %v, want %v", got, want)
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("missed ticks were queued")
+	default:
+	}
+}
+
+func TestManualClockAfter(t *testing.T) {
+	mc := useManualClock(t)
+	start := mc.Now()
+	after := mc.After(time.Minute)
+
+	mc.Advance(59 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	mc.Advance(time.Second)
+	if got := <-after; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("After delivered %v, want %v", got, start.Add(time.Minute))
+	}
+}
+
+func TestManualClockNonPositiveTickerPanics(t *testing.T) {
+	mc := useManualClock(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("NewTicker(0) did not panic")
+		}
+	}()
+	mc.NewTicker(0)
+}
+
+func TestDIDGrouperFlushesAfterWindow(t *testing.T) {
+	mc := useManualClock(t)
+	var flushed []string
+	g := newDIDGrouper(2*time.Second, 0, func(did string, events []Event) {
+		flushed = append(flushed, did)
+	})
+
+	g.Add(Event{Did: "did:plc:a"})
+	mc.Advance(time.Second)
+	g.Add(Event{Did: "did:plc:b"})
+
+	g.flushExpired(mc.Now())
+	if len(flushed) != 0 {
+		t.Fatalf("flushed %v before any window elapsed", flushed)
+	}
+	mc.Advance(time.Second)
+	g.flushExpired(mc.Now())
+	if len(flushed) != 1 || flushed[0] != "did:plc:a" {
+		t.Fatalf("flushed %v, want only did:plc:a", flushed)
+	}
+	mc.Advance(time.Second)
+	g.flushExpired(mc.Now())
+	if len(flushed) != 2 || flushed[1] != "did:plc:b" {
+		t.Fatalf("flushed %v, want did:plc:a then did:plc:b", flushed)
+	}
+}
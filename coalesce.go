@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// coalescer holds back commits for window and emits only the last one seen
+// for each AT-URI, so a record created and then edited several times in
+// quick succession comes out once in its final state. The window starts at
+// a record's first pending commit and is not extended by later ones, so a
+// record that keeps changing is still emitted every window. Other events
+// are emitted straight away.
+type coalescer struct {
+	mu         sync.Mutex
+	window     time.Duration
+	pending    map[string]*pendingCommit
+	order      []string
+	emit       func(event Event)
+	superseded atomic.Uint64
+}
+
+// pendingCommit is the latest commit held back for one AT-URI
+type pendingCommit struct {
+	started time.Time
+	event   Event
+}
+
+func newCoalescer(window time.Duration, emit func(event Event)) *coalescer {
+	return &coalescer{
+		window:  window,
+		pending: make(map[string]*pendingCommit),
+		emit:    emit,
+	}
+}
+
+// Add holds back a commit, replacing any pending one for the same record
+func (c *coalescer) Add(event Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if event.Commit == nil {
+		c.emit(event)
+		return
+	}
+	uri := atURI(event)
+	if p, ok := c.pending[uri]; ok {
+		p.event = event
+		c.superseded.Add(1)
+		return
+	}
+	c.pending[uri] = &pendingCommit{started: clock.Now(), event: event}
+	c.order = append(c.order, uri)
+}
+
+// Run emits commits whose window has elapsed until stop is closed
+func (c *coalescer) Run(stop <-chan struct{}) {
+	ticker := clock.NewTicker(max(c.window/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C():
+			c.flushExpired(now)
+		}
+	}
+}
+
+// Flush emits every pending commit, oldest first
+func (c *coalescer) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 {
+		c.flushOldestLocked()
+	}
+}
+
+func (c *coalescer) flushExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.order) > 0 && now.Sub(c.pending[c.order[0]].started) >= c.window {
+		c.flushOldestLocked()
+	}
+}
+
+func (c *coalescer) flushOldestLocked() {
+	uri := c.order[0]
+	c.order = c.order[1:]
+	p := c.pending[uri]
+	delete(c.pending, uri)
+	c.emit(p.event)
+}
+
+// reportCoalesced returns a stats reporter printing how many commits were
+// replaced by a later one for the same record
+func reportCoalesced(c *coalescer) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintf(w, "Commits coalesced: %d\n", c.superseded.Load())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// commitEvent returns a commit event for a post with the given record key
+func commitEvent(rkey, operation string) Event {
+	return Event{
+		Did:    "did:plc:a",
+		Kind:   "commit",
+		Commit: &Commit{Operation: operation, Collection: "app.bsky.feed.post", RKey: rkey},
+	}
+}
+
+// collectEmitted returns a coalescer emit function and the events it
+// received, as operation/rkey pairs
+func collectEmitted() (func(Event), *[]string) {
+	var emitted []string
+	return func(event Event) {
+		if event.Commit == nil {
+			emitted = append(emitted, event.Kind)
+			return
+		}
+		emitted = append(emitted, event.Commit.Operation+"/"+event.Commit.RKey)
+	}, &emitted
+}
+
+func TestCoalescerWindowStartsAtFirstCommit(t *testing.T) {
+	mc := useManualClock(t)
+	emit, emitted := collectEmitted()
+	c := newCoalescer(2*time.Second, emit)
+
+	c.Add(commitEvent("a", "create"))
+	mc.Advance(time.Second)
+	c.Add(commitEvent("a", "update"))
+	c.Add(commitEvent("b", "create"))
+
+	c.flushExpired(mc.Now())
+	if len(*emitted) != 0 {
+		t.Fatalf("emitted %v before any window elapsed", *emitted)
+	}
+
+	// The update did not restart a's window, so it is due two seconds after
+	// the create, and comes out as its latest version
+	mc.Advance(time.Second)
+	c.flushExpired(mc.Now())
+	if got := *emitted; len(got) != 1 || got[0] != "update/a" {
+		t.Fatalf("emitted %v, want only update/a", got)
+	}
+
+	mc.Advance(time.Second)
+	c.flushExpired(mc.Now())
+	if got := *emitted; len(got) != 2 || got[1] != "create/b" {
+		t.Fatalf("emitted %v, want update/a then create/b", got)
+	}
+	if n := c.superseded.Load(); n != 1 {
+		t.Errorf("superseded = %d, want 1", n)
+	}
+}
+
+func TestCoalescerDeleteReplacesPendingCommit(t *testing.T) {
+	mc := useManualClock(t)
+	emit, emitted := collectEmitted()
+	c := newCoalescer(time.Second, emit)
+
+	c.Add(commitEvent("a", "create"))
+	c.Add(commitEvent("a", "update"))
+	c.Add(commitEvent("a", "delete"))
+	mc.Advance(time.Second)
+	c.flushExpired(mc.Now())
+
+	if got := *emitted; len(got) != 1 || got[0] != "delete/a" {
+		t.Fatalf("emitted %v, want only delete/a", got)
+	}
+	if n := c.superseded.Load(); n != 2 {
+		t.Errorf("superseded = %d, want 2", n)
+	}
+}
+
+func TestCoalescerPassesOtherEventsThrough(t *testing.T) {
+	useManualClock(t)
+	emit, emitted := collectEmitted()
+	c := newCoalescer(time.Minute, emit)
+
+	c.Add(commitEvent("a", "create"))
+	c.Add(Event{Did: "did:plc:a", Kind: "identity", Identity: &Identity{}})
+	c.Add(Event{Did: "did:plc:a", Kind: "account", Account: &Account{}})
+
+	if got := *emitted; len(got) != 2 || got[0] != "identity" || got[1] != "account" {
+		t.Fatalf("emitted %v, want identity and account straight away", got)
+	}
+}
+
+func TestCoalescerFlushEmitsEverythingPending(t *testing.T) {
+	useManualClock(t)
+	emit, emitted := collectEmitted()
+	c := newCoalescer(time.Minute, emit)
+
+	c.Add(commitEvent("a", "create"))
+	c.Add(commitEvent("b", "create"))
+	c.Add(commitEvent("a", "update"))
+	c.Flush()
+
+	if got := *emitted; len(got) != 2 || got[0] != "update/a" || got[1] != "create/b" {
+		t.Fatalf("emitted %v, want update/a then create/b", got)
+	}
+	c.Flush()
+	if len(*emitted) != 2 {
+		t.Errorf("second Flush emitted again: %v", *emitted)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is the subset of a WebSocket connection the consumer relies on.
+// *websocket.Conn satisfies it, so tests and alternate transports can
+// supply their own implementation.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+// Dialer opens a Conn to the firehose at url, sending header with the
+// handshake request
+type Dialer func(url string, header http.Header) (Conn, error)
+
+// DialWebsocket is the default Dialer, backed by gorilla/websocket
+func DialWebsocket(u string, header http.Header) (Conn, error) {
+	c, _, err := websocket.DefaultDialer.Dial(u, header)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// subscribeURL builds the URL Run subscribes to. Jetstream subscription
+// options (wantedCollections, wantedDids, cursor, compress) would be added
+// here as query parameters; the consumer sets none of them yet, so this is
+// the bare endpoint.
+func subscribeURL() string {
+	return wsURL
+}
+
+// reservedHeaders are set by the WebSocket handshake itself and cannot be
+// overridden
+var reservedHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// headerFlags collects repeated --header "Key: Value" flags
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	var parts []string
+	for key, values := range h {
+		for _, value := range values {
+			parts = append(parts, key+": "+value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h headerFlags) Set(s string) error {
+	key, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("header %q must be in Key: Value form", s)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if key == "" || strings.IndexFunc(key, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+		return fmt.Errorf("invalid header name %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %q value must not contain line breaks", key)
+	}
+	key = http.CanonicalHeaderKey(key)
+	if reservedHeaders[key] {
+		return fmt.Errorf("header %q is set by the WebSocket handshake", key)
+	}
+	http.Header(h).Add(key, value)
+	return nil
+}
+
+// isTokenChar reports whether r may appear in an HTTP header name
+func isTokenChar(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// isSlowConsumerClose reports whether err is the server closing the
+// connection because the consumer fell behind. Jetstream does not document
+// a dedicated close code, so this matches "try again later" closes and any
+// close whose reason mentions being slow.
+func isSlowConsumerClose(err error) bool {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	return closeErr.Code == websocket.CloseTryAgainLater ||
+		strings.Contains(strings.ToLower(closeErr.Text), "slow")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboardLogLines is how many recent log lines the dashboard shows
+const dashboardLogLines = 5
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// logTail keeps the last few lines written to it so the dashboard can show
+// log output without it scrolling the screen
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.lines = append(t.lines, line)
+	}
+	if len(t.lines) > dashboardLogLines {
+		t.lines = t.lines[len(t.lines)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+func (t *logTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// dashboard redraws a live view of the stats counters in place on w,
+// refreshed on every stats interval
+type dashboard struct {
+	w        io.Writer
+	interval time.Duration
+	logs     *logTail
+}
+
+// Run redraws the dashboard each time ticker fires
+func (d *dashboard) Run(ticker Ticker) {
+	for range ticker.C() {
+		d.draw(stats.messages.Load(), stats.sample(d.interval))
+	}
+}
+
+func (d *dashboard) draw(total uint64, sample statsSample) {
+	var b bytes.Buffer
+	// Move home and clear the screen before redrawing
+	b.WriteString("\x1b[H\x1b[2J")
+
+	status, since := stats.connStatus()
+	fmt.Fprintf(&b, "Bluesky Firehose  %s for %s\n\n", status, since.Truncate(time.Second))
+	fmt.Fprintf(&b, "Messages: %d total, %.0f/s\n", total, sample.messagesPerSec)
+	if perHour, ok := stats.estimatedPostsPerHour(); ok {
+		fmt.Fprintf(&b, "Posts: %d total, ~%.0f/hour\n", stats.posts.Load(), perHour)
+	}
+	if line := sampleRatios(sample); line != "" {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, "%-44s %12s %7s\n", "Collection", "Events", "Share")
+	commits := stats.totalCommits()
+	for _, c := range stats.topCollections(10) {
+		fmt.Fprintf(&b, "%-44s %12d %6.1f%%\n", c.name, c.count, percent(c.count, commits))
+	}
+
+	fmt.Fprintf(&b, "\n%-44s %12s\n", "Language (created posts)", "Posts")
+	for _, l := range stats.topLangs(5) {
+		fmt.Fprintf(&b, "%-44s %12d\n", l.name, l.count)
+	}
+
+	if len(statsReporters) > 0 {
+		b.WriteString("\n")
+		for _, report := range statsReporters {
+			report(&b)
+		}
+	}
+
+	if d.logs != nil {
+		if lines := d.logs.Lines(); len(lines) > 0 {
+			b.WriteString("\nRecent logs\n")
+			for _, line := range lines {
+				b.WriteString(line + "\n")
+			}
+		}
+	}
+
+	d.w.Write(b.Bytes())
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
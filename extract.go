@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathStep is one step of an --extract path: an object key or array index
+type pathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parsePath parses a dotted path such as commit.record.langs[0]. Keys are
+// separated by dots and may be followed by any number of [n] indexes.
+func parsePath(s string) ([]pathStep, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	var steps []pathStep
+	for _, segment := range strings.Split(s, ".") {
+		key, rest, hasIndex := strings.Cut(segment, "[")
+		if strings.Contains(key, "]") {
+			return nil, fmt.Errorf("unexpected ] in path %q", s)
+		}
+		if key == "" && (!hasIndex || len(steps) > 0) {
+			return nil, fmt.Errorf("empty key in path %q", s)
+		}
+		if key != "" {
+			steps = append(steps, pathStep{key: key})
+		}
+		for hasIndex {
+			num, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("unterminated index in path %q", s)
+			}
+			index, err := strconv.Atoi(num)
+			if err != nil || strings.Trim(num, "0123456789") != "" {
+				return nil, fmt.Errorf("invalid index in path %q", s)
+			}
+			steps = append(steps, pathStep{index: index, isIndex: true})
+			if after == "" {
+				break
+			}
+			if after[0] != '[' {
+				return nil, fmt.Errorf("unexpected %q after index in path %q", after, s)
+			}
+			rest = after[1:]
+		}
+	}
+	return steps, nil
+}
+
+// walkPath follows steps through a decoded JSON value, reporting false if
+// any step is missing or of the wrong type
+func walkPath(v any, steps []pathStep) (any, bool) {
+	for _, step := range steps {
+		if step.isIndex {
+			arr, ok := v.([]any)
+			if !ok || step.index >= len(arr) {
+				return nil, false
+			}
+			v = arr[step.index]
+			continue
+		}
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[step.key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// extractValue returns the value at steps within a raw event as a single
+// output line. Strings are printed as-is and anything else as compact JSON.
+func extractValue(raw []byte, steps []pathStep) (string, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return "", false, err
+	}
+
+	v, ok := walkPath(doc, steps)
+	if !ok {
+		return "", false, nil
+	}
+	if s, isString := v.(string); isString {
+		return s, true, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// printExtract prints the --extract value of an event, skipping events
+// that lack the path
+func printExtract(event Event) {
+	value, ok, err := extractValue(event.Raw, extractSteps)
+	if err != nil {
+		recordDecodeError(fmt.Errorf("event for --extract: %w", err))
+		return
+	}
+	if ok {
+		fmt.Fprintln(out, value)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	key := func(k string) pathStep { return pathStep{key: k} }
+	index := func(i int) pathStep { return pathStep{index: i, isIndex: true} }
+
+	tests := []struct {
+		path string
+		want []pathStep
+	}{
+		{"did", []pathStep{key("did")}},
+		{"commit.record.text", []pathStep{key("commit"), key("record"), key("text")}},
+		{"commit.record.langs[0]", []pathStep{key("commit"), key("record"), key("langs"), index(0)}},
+		{"facets[0].features[12]", []pathStep{key("facets"), index(0), key("features"), index(12)}},
+		{"matrix[1][2]", []pathStep{key("matrix"), index(1), index(2)}},
+		{"[3].uri", []pathStep{index(3), key("uri")}},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if err != nil {
+			t.Errorf("parsePath(%q): %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParsePathInvalid(t *testing.T) {
+	for _, path := range []string{
+		"",
+		".",
+		"commit.",
+		".commit",
+		"commit..record",
+		"commit.[0]",
+		"commit.record.langs[",
+		"langs[0",
+		"langs[0][",
+		"langs[]",
+		"langs[x]",
+		"langs[-1]",
+		"langs[+1]",
+		"langs[0]x",
+		"a]",
+		"a]b[0]",
+	} {
+		if steps, err := parsePath(path); err == nil {
+			t.Errorf("parsePath(%q) = %+v, want an error", path, steps)
+		}
+	}
+}
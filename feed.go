@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// feedTitleLength is how many characters of post text make an entry title
+const feedTitleLength = 80
+
+// feedItem is a post kept for the feed
+type feedItem struct {
+	uri       string
+	did       string
+	text      string
+	createdAt time.Time
+}
+
+// feedRing holds the most recent posts that passed the filters, oldest
+// overwritten first. It is shared between the processing path and the
+// HTTP handler.
+type feedRing struct {
+	mu    sync.Mutex
+	items []feedItem
+	next  int
+	full  bool
+}
+
+func newFeedRing(size int) *feedRing {
+	return &feedRing{items: make([]feedItem, size)}
+}
+
+func (r *feedRing) Add(item feedItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items[r.next] = item
+	r.next = (r.next + 1) % len(r.items)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns the buffered posts, newest first
+func (r *feedRing) Recent() []feedItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.items)
+	}
+	recent := make([]feedItem, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, r.items[(r.next-i+len(r.items))%len(r.items)])
+	}
+	return recent
+}
+
+// Atom document structure, see RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// serveFeed writes the ring's posts as an Atom feed
+func serveFeed(ring *feedRing, title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		self := "http://" + r.Host + r.URL.Path
+		feed := atomFeed{
+			Title:   title,
+			ID:      self,
+			Updated: clock.Now().UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: self, Rel: "self"},
+		}
+		for _, item := range ring.Recent() {
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   feedEntryTitle(item.text),
+				ID:      item.uri,
+				Link:    atomLink{Href: item.uri},
+				Updated: item.createdAt.UTC().Format(time.RFC3339),
+				Author:  atomAuthor{Name: item.did},
+				Content: atomContent{Type: "text", Body: item.text},
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		fmt.Fprint(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(feed); err != nil {
+			log.Printf("Error writing feed: %v", err)
+		}
+	}
+}
+
+// feedEntryTitle shortens post text to a one-line entry title
+func feedEntryTitle(text string) string {
+	if text == "" {
+		return "(no text)"
+	}
+	if utf8.RuneCountInString(text) <= feedTitleLength {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:feedTitleLength]) + "…"
+}
+
+// startFeedServer serves the feed at /feed.xml on addr in the background.
+// The address is bound before returning, so a port in use is fatal at
+// startup rather than logged after the consumer is already running.
+func startFeedServer(addr string, ring *feedRing, title string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("feed server: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/feed.xml", serveFeed(ring, title))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("feed server: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appendFile is a buffered output file opened in append mode
+type appendFile struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func (f *appendFile) close() error {
+	if err := f.writer.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// appendFiles is a directory of <name>.ndjson files of which only the
+// maxOpen most recently written are kept open. Others are closed and
+// reopened in append mode when they are written to again.
+type appendFiles struct {
+	dir     string
+	maxOpen int
+	files   *lruCache[string, *appendFile]
+}
+
+func newAppendFiles(dir string, maxOpen int) (*appendFiles, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", dir, err)
+	}
+	a := &appendFiles{dir: dir, maxOpen: maxOpen, files: newLRU[string, *appendFile](maxOpen)}
+	a.files.onEvict = func(name string, f *appendFile) {
+		if err := f.close(); err != nil {
+			log.Printf("Error closing %s: %v", f.file.Name(), err)
+		}
+	}
+	return a, nil
+}
+
+// WriteLine appends line and a newline to the named file
+func (a *appendFiles) WriteLine(name string, line []byte) error {
+	f, ok := a.files.Get(name)
+	if !ok {
+		path := filepath.Join(a.dir, safeFilename(name)+".ndjson")
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", path, err)
+		}
+		f = &appendFile{file: file, writer: bufio.NewWriter(file)}
+		a.files.Put(name, f)
+	}
+
+	f.writer.Write(line)
+	if err := f.writer.WriteByte('\n'); err != nil {
+		return fmt.Errorf("write %s: %w", f.file.Name(), err)
+	}
+	return nil
+}
+
+// Flush writes out every open file's buffer
+func (a *appendFiles) Flush() error {
+	var firstErr error
+	a.files.Each(func(name string, f *appendFile) {
+		if err := f.writer.Flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("flush %s: %w", f.file.Name(), err)
+		}
+	})
+	return firstErr
+}
+
+// shrinkMemory closes all but a fraction of the open files, releasing
+// their buffers
+func (a *appendFiles) shrinkMemory() {
+	a.files.Resize(max(1, a.maxOpen/memoryShrinkFactor))
+}
+
+// restoreMemory allows maxOpen files to be open again
+func (a *appendFiles) restoreMemory() {
+	a.files.Resize(a.maxOpen)
+}
+
+// Close flushes and closes every open file, returning the first error
+func (a *appendFiles) Close() error {
+	var firstErr error
+	a.files.onEvict = func(name string, f *appendFile) {
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close %s: %w", f.file.Name(), err)
+		}
+	}
+	a.files.Purge()
+	return firstErr
+}
+
+// safeFilename turns a DID or other name into a safe file name by
+// replacing every character other than letters, digits, '.', '-' and '_'
+// with '_', so did:plc:abc becomes did_plc_abc
+func safeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// droppedNoLangs counts posts dropped by --require-langs
+var droppedNoLangs atomic.Uint64
+
+// threads are the thread root AT-URIs given with --thread
+var threads = threadFlags{}
+
+// threadFlags collects repeated --thread at://... flags
+type threadFlags map[string]bool
+
+func (t threadFlags) String() string {
+	return strings.Join(sortedKeys(t), ", ")
+}
+
+func (t threadFlags) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "at://") || len(strings.Split(strings.TrimPrefix(s, "at://"), "/")) != 3 {
+		return fmt.Errorf("thread %q must be a post AT-URI like at://did:plc:abc/app.bsky.feed.post/3k...", s)
+	}
+	t[s] = true
+	return nil
+}
+
+// eventAllowed reports whether an event passes the configured filters.
+// Filtered events are neither printed nor sent to sinks.
+func eventAllowed(event Event) bool {
+	switch {
+	case event.Commit != nil:
+		return commitAllowed(event.Commit) && postAllowed(event)
+	case event.Identity != nil:
+		return identities == nil || identities.Changed(event)
+	}
+	return true
+}
+
+// commitAllowed reports whether a commit passes the collection and record
+// key filters
+func commitAllowed(commit *Commit) bool {
+	if *verificationsOnly && commit.Collection != verificationCollection {
+		return false
+	}
+	if *rkeyPrefix != "" && !strings.HasPrefix(commit.RKey, *rkeyPrefix) {
+		return false
+	}
+	if *rkeyGlob != "" {
+		// The pattern is validated at startup, so the error can be ignored
+		if ok, _ := path.Match(*rkeyGlob, commit.RKey); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// postFiltersEnabled reports whether any filter needs the decoded post
+func postFiltersEnabled() bool {
+	return *requireLangs || len(threads) > 0
+}
+
+// postAllowed reports whether a created or updated post passes the post
+// filters. Other commits always pass, as do posts that fail to decode so
+// the error is reported where the post is processed.
+func postAllowed(event Event) bool {
+	if !postFiltersEnabled() || event.Commit.Collection != "app.bsky.feed.post" {
+		return true
+	}
+	if event.Commit.Operation != "create" && event.Commit.Operation != "update" {
+		return true
+	}
+	var post Post
+	if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+		return true
+	}
+
+	if *requireLangs && len(post.Langs) == 0 {
+		droppedNoLangs.Add(1)
+		return false
+	}
+	if len(threads) > 0 && !inThread(event, post) {
+		return false
+	}
+	return true
+}
+
+// reportDroppedNoLangs prints how many posts --require-langs has dropped
+func reportDroppedNoLangs(w io.Writer) {
+	fmt.Fprintf(w, "Posts dropped without langs: %d\n", droppedNoLangs.Load())
+}
+
+// inThread reports whether a post is a reply in one of the --thread threads,
+// or is one of the roots itself
+func inThread(event Event, post Post) bool {
+	if post.Reply != nil && threads[post.Reply.Root.URI] {
+		return true
+	}
+	return threads[atURI(event)]
+}
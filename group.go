@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// didGrouper buffers events and emits them in per-DID batches. A DID's
+// batch is flushed once it holds max events or its oldest event has waited
+// for window, so grouping adds up to one window of output latency.
+type didGrouper struct {
+	mu     sync.Mutex
+	window time.Duration
+	max    int
+	groups map[string]*didGroup
+	order  []string
+	emit   func(did string, events []Event)
+}
+
+// didGroup holds the pending events for a single DID
+type didGroup struct {
+	started time.Time
+	events  []Event
+}
+
+func newDIDGrouper(window time.Duration, max int, emit func(did string, events []Event)) *didGrouper {
+	return &didGrouper{
+		window: window,
+		max:    max,
+		groups: make(map[string]*didGroup),
+		emit:   emit,
+	}
+}
+
+// Add buffers an event, flushing its DID's group early if it is full
+func (g *didGrouper) Add(event Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	group, ok := g.groups[event.Did]
+	if !ok {
+		group = &didGroup{started: clock.Now()}
+		g.groups[event.Did] = group
+		g.order = append(g.order, event.Did)
+	}
+	group.events = append(group.events, event)
+
+	if g.max > 0 && len(group.events) >= g.max {
+		g.flushLocked(event.Did)
+	}
+}
+
+// Run flushes groups whose window has elapsed until stop is closed
+func (g *didGrouper) Run(stop <-chan struct{}) {
+	ticker := clock.NewTicker(max(g.window/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C():
+			g.flushExpired(now)
+		}
+	}
+}
+
+// Flush emits every pending group, oldest first
+func (g *didGrouper) Flush() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(g.order) > 0 {
+		g.flushLocked(g.order[0])
+	}
+}
+
+func (g *didGrouper) flushExpired(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(g.order) > 0 {
+		did := g.order[0]
+		if now.Sub(g.groups[did].started) < g.window {
+			return
+		}
+		g.flushLocked(did)
+	}
+}
+
+func (g *didGrouper) flushLocked(did string) {
+	group, ok := g.groups[did]
+	if !ok {
+		return
+	}
+	delete(g.groups, did)
+	for i, d := range g.order {
+		if d == did {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+	g.emit(did, group.events)
+}
+
+// groupOutput holds back output while a DID group is printed, so a group
+// whose events print nothing gets no header. It wraps out when grouping is
+// enabled; anything else written meanwhile, such as stats, is held back too.
+type groupOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	holding bool
+	held    [][]byte
+}
+
+// groupOut is the output wrapper used by printDIDGroup
+var groupOut *groupOutput
+
+func (g *groupOutput) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.holding {
+		g.held = append(g.held, bytes.Clone(p))
+		return len(p), nil
+	}
+	return g.w.Write(p)
+}
+
+// hold starts holding back writes
+func (g *groupOutput) hold() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holding = true
+}
+
+// release writes header followed by the held writes, one write each so
+// line-based writers such as syslog keep their messages. Nothing is written
+// if nothing was held.
+func (g *groupOutput) release(header string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.holding = false
+	if len(g.held) == 0 {
+		return
+	}
+	fmt.Fprint(g.w, header)
+	for _, p := range g.held {
+		g.w.Write(p)
+	}
+	g.held = nil
+}
+
+// printDIDGroup writes a group header followed by each event in the group.
+// The header counts only the events that pass the filters, and is left out
+// when none of them print anything.
+func printDIDGroup(did string, events []Event) {
+	processMu.Lock()
+	defer processMu.Unlock()
+
+	groupOut.hold()
+	allowed := 0
+	for _, event := range events {
+		if processEventLocked(event) {
+			allowed++
+		}
+	}
+	groupOut.release(fmt.Sprintf("\n--- DID Group: %s (%d events) ---\n", did, allowed))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tagTracker keeps a rolling count of hashtags. The map is capped at
+// capacity entries using the space-saving scheme: when full, the least
+// frequent tag is replaced and the newcomer inherits its count, which keeps
+// heavy hitters accurate at bounded memory. Counts are halved after every
+// report so the ranking follows what is trending now.
+type tagTracker struct {
+	mu       sync.Mutex
+	capacity int
+	counts   map[string]int
+}
+
+func newTagTracker(capacity int) *tagTracker {
+	return &tagTracker{capacity: capacity, counts: make(map[string]int)}
+}
+
+// Observe counts each of a post's hashtags, normalized to lowercase
+func (t *tagTracker) Observe(tags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		if tag == "" {
+			continue
+		}
+		if _, ok := t.counts[tag]; !ok && len(t.counts) >= t.capacity {
+			minTag, minCount := "", 0
+			for k, c := range t.counts {
+				if minTag == "" || c < minCount {
+					minTag, minCount = k, c
+				}
+			}
+			delete(t.counts, minTag)
+			t.counts[tag] = minCount
+		}
+		t.counts[tag]++
+	}
+}
+
+// Top returns up to n tags in descending order of count
+func (t *tagTracker) Top(n int) []tagCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	top := make([]tagCount, 0, len(t.counts))
+	for tag, count := range t.counts {
+		top = append(top, tagCount{tag, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].tag < top[j].tag
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Resize changes the number of distinct tags tracked, dropping the least
+// frequent ones if there are now too many
+func (t *tagTracker) Resize(capacity int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.capacity = capacity
+	if len(t.counts) <= capacity {
+		return
+	}
+	all := make([]tagCount, 0, len(t.counts))
+	for tag, count := range t.counts {
+		all = append(all, tagCount{tag, count})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].count > all[j].count })
+	for _, tc := range all[capacity:] {
+		delete(t.counts, tc.tag)
+	}
+}
+
+// decay halves every count, forgetting tags that fall to zero
+func (t *tagTracker) decay() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag, count := range t.counts {
+		if count /= 2; count == 0 {
+			delete(t.counts, tag)
+		} else {
+			t.counts[tag] = count
+		}
+	}
+}
+
+type tagCount struct {
+	tag   string
+	count int
+}
+
+// reportTopTags returns a stats reporter printing the top n hashtags
+func reportTopTags(t *tagTracker, n int) func(w io.Writer) {
+	return func(w io.Writer) {
+		top := t.Top(n)
+		if len(top) == 0 {
+			return
+		}
+		parts := make([]string, len(top))
+		for i, tc := range top {
+			parts[i] = fmt.Sprintf("#%s (%d)", tc.tag, tc.count)
+		}
+		fmt.Fprintf(w, "Top hashtags: %s\n", strings.Join(parts, ", "))
+		t.decay()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// identityState is the last seen identity of a DID
+type identityState struct {
+	handle      string
+	displayName string
+	description string
+}
+
+// identityTracker suppresses identity events that repeat a DID's last seen
+// handle, display name and description. The first event seen for a DID is
+// always emitted, and only the most recently active DIDs are remembered.
+type identityTracker struct {
+	seen       *lruCache[string, identityState]
+	emitted    atomic.Uint64
+	suppressed atomic.Uint64
+}
+
+func newIdentityTracker(capacity int) *identityTracker {
+	return &identityTracker{seen: newLRU[string, identityState](capacity)}
+}
+
+// Changed records the event's identity and reports whether it differs from
+// what was last seen for the DID
+func (t *identityTracker) Changed(event Event) bool {
+	current := identityState{
+		handle:      event.Identity.Handle,
+		displayName: event.Identity.DisplayName,
+		description: event.Identity.Description,
+	}
+	if previous, ok := t.seen.Get(event.Did); ok && previous == current {
+		t.suppressed.Add(1)
+		return false
+	}
+	t.seen.Put(event.Did, current)
+	t.emitted.Add(1)
+	return true
+}
+
+// reportIdentityChanges returns a stats reporter printing emitted and
+// suppressed identity event totals
+func reportIdentityChanges(t *identityTracker) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintf(w, "Identity events emitted: %d, suppressed: %d\n", t.emitted.Load(), t.suppressed.Load())
+	}
+}
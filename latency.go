@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencySamples is how many latencies are kept each interval for the
+// percentiles, chosen by reservoir sampling
+const latencySamples = 1024
+
+// latencyWindow accumulates delivery latencies over an interval: the time
+// from an event's time_us to when it was read. Events stamped after the
+// local clock are counted as ahead and sampled as zero.
+type latencyWindow struct {
+	events  uint64
+	ahead   uint64
+	max     time.Duration
+	samples []time.Duration
+}
+
+// Observe records the latency of an event
+func (w *latencyWindow) Observe(event Event) {
+	d := event.Received.Sub(time.UnixMicro(event.TimeUS))
+	if d < 0 {
+		w.ahead++
+		d = 0
+	}
+	w.events++
+	w.max = max(w.max, d)
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, d)
+	} else if i := rand.Uint64N(w.events); i < latencySamples {
+		w.samples[i] = d
+	}
+}
+
+// percentile returns the p-th percentile of the sampled latencies
+func (w *latencyWindow) percentile(p int) time.Duration {
+	if len(w.samples) == 0 {
+		return 0
+	}
+	slices.Sort(w.samples)
+	return w.samples[(len(w.samples)-1)*p/100]
+}
+
+// latencyTracker is a latencyWindow shared between the reader and the
+// stats goroutine
+type latencyTracker struct {
+	mu     sync.Mutex
+	window latencyWindow
+}
+
+// Observe records the latency of an event
+func (l *latencyTracker) Observe(event Event) {
+	l.mu.Lock()
+	l.window.Observe(event)
+	l.mu.Unlock()
+}
+
+// take returns the interval's latencies and starts a new interval
+func (l *latencyTracker) take() latencyWindow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.window
+	l.window = latencyWindow{}
+	return w
+}
+
+// reportLatency returns a stats reporter printing the interval's latency
+// percentiles
+func reportLatency(l *latencyTracker) func(w io.Writer) {
+	return func(w io.Writer) {
+		window := l.take()
+		if window.events == 0 {
+			return
+		}
+		fmt.Fprintf(w, "Latency: p50 %s, p90 %s, p99 %s, max %s\n",
+			roundLatency(window.percentile(50)), roundLatency(window.percentile(90)),
+			roundLatency(window.percentile(99)), roundLatency(window.max))
+		if window.ahead > 0 {
+			fmt.Fprintf(w, "  %d of %d events were stamped ahead of the local clock; check for clock skew\n",
+				window.ahead, window.events)
+		}
+	}
+}
+
+// roundLatency rounds to a precision that suits the magnitude
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Millisecond)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// lifecycleEvent is one line of the --lifecycle-log stream
+type lifecycleEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	URL   string    `json:"url,omitempty"`
+	// Reason explains a disconnect, failed dial or shutdown
+	Reason string `json:"reason,omitempty"`
+	// Code is the WebSocket close code, when the server sent one
+	Code int `json:"code,omitempty"`
+	// Messages is how many messages had been read when the event happened
+	Messages uint64 `json:"messages"`
+	// LastTimeUS is the time_us of the last event read, the cursor a
+	// reconnect would resume from
+	LastTimeUS int64 `json:"last_time_us,omitempty"`
+}
+
+var (
+	lifecycleMu  sync.Mutex
+	lifecycleOut io.Writer
+
+	// lastTimeUS is the time_us of the last event read, kept for the
+	// lifecycle stream
+	lastTimeUS atomic.Int64
+)
+
+// setupLifecycleLog sends lifecycle events to path, or stderr for "-"
+func setupLifecycleLog(path string) error {
+	if path == "-" {
+		lifecycleOut = os.Stderr
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("lifecycle log: %w", err)
+	}
+	lifecycleOut = file
+	return nil
+}
+
+// emitLifecycle writes a lifecycle event as a JSON line, filling in the
+// time and progress fields, if --lifecycle-log is set
+func emitLifecycle(e lifecycleEvent) {
+	if lifecycleOut == nil {
+		return
+	}
+	e.Time = clock.Now().UTC()
+	e.Messages = stats.messages.Load()
+	e.LastTimeUS = lastTimeUS.Load()
+	line, _ := json.Marshal(e)
+
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+	if _, err := lifecycleOut.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing lifecycle event: %v", err)
+	}
+}
+
+// disconnectEvent describes a read error, including the close code and
+// reason when the server closed the connection
+func disconnectEvent(err error) lifecycleEvent {
+	e := lifecycleEvent{Event: "disconnected", Reason: err.Error()}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		e.Code, e.Reason = closeErr.Code, closeErr.Text
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"container/list"
+)
+
+// lruCache is a fixed-capacity map that evicts its least recently used
+// entry when full. It is not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	capacity int
+	ll       *list.List
+	items    map[K]*list.Element
+
+	// onEvict, if set, is called with each entry removed to make room
+	onEvict func(key K, value V)
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRU[K comparable, V any](capacity int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value for key and marks it as recently used
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Put stores value under key, evicting the least recently used entry if
+// the cache is full
+func (c *lruCache[K, V]) Put(key K, value V) {
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*lruEntry[K, V]).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key, value})
+	c.Resize(c.capacity)
+}
+
+// Remove deletes key from the cache, if present
+func (c *lruCache[K, V]) Remove(key K) {
+	if elem, ok := c.items[key]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// Len returns the number of entries in the cache
+func (c *lruCache[K, V]) Len() int {
+	return c.ll.Len()
+}
+
+// Resize changes the capacity, evicting least recently used entries if the
+// cache now holds too many
+func (c *lruCache[K, V]) Resize(capacity int) {
+	c.capacity = capacity
+	for c.ll.Len() > c.capacity {
+		c.removeOldest()
+	}
+}
+
+// Each calls fn for every entry, most recently used first, without
+// changing their order
+func (c *lruCache[K, V]) Each(fn func(key K, value V)) {
+	for elem := c.ll.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*lruEntry[K, V])
+		fn(entry.key, entry.value)
+	}
+}
+
+// Purge removes every entry, calling onEvict for each
+func (c *lruCache[K, V]) Purge() {
+	for c.ll.Len() > 0 {
+		c.removeOldest()
+	}
+}
+
+func (c *lruCache[K, V]) removeOldest() {
+	oldest := c.ll.Back()
+	c.ll.Remove(oldest)
+	entry := oldest.Value.(*lruEntry[K, V])
+	delete(c.items, entry.key)
+	if c.onEvict != nil {
+		c.onEvict(entry.key, entry.value)
+	}
+}
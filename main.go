@@ -2,11 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,6 +21,161 @@ import (
 
 var wsURL = "wss://jetstream2.us-east.bsky.network/subscribe"
 
+// version identifies this consumer in the default User-Agent
+const version = "0.1.0"
+
+// headers are sent with the WebSocket handshake, filled from --header
+var headers = headerFlags{}
+
+// updateDiffs, if set, caches records for --update-diff
+var updateDiffs *recordCache
+
+// latency, if set, measures delivery latency for --latency
+var latency *latencyTracker
+
+// activity, if set, tracks events per DID for --did-activity
+var activity *activityTracker
+
+// previewing, if set, counts filter matches for --preview instead of
+// processing events
+var previewing *previewCounter
+
+// schemaSamples, if set, logs sampled raw messages for --schema-samples
+var schemaSamples *schemaSampler
+
+// out receives all event and stats output
+var out io.Writer = os.Stdout
+
+// postRates tracks per-DID posting rates when alerts are enabled
+var postRates *postRateMonitor
+
+// topTags counts hashtags when --top-tags is set
+var topTags *tagTracker
+
+// extractSteps is the parsed --extract path, nil when extraction is off
+var extractSteps []pathStep
+
+// feed keeps recent posts for the Atom feed when --feed-addr is set
+var feed *feedRing
+
+// sentiment scores post text when --sentiment is set
+var sentiment Scorer
+
+// memGuard enforces --max-memory when set
+var memGuard *memoryGuard
+
+// bandwidth records message sizes when --bandwidth is set
+var bandwidth *bandwidthTracker
+
+// dash is the live dashboard when --dashboard is set and stderr is a terminal
+var dash *dashboard
+
+// identities remembers each DID's identity when --identity-changes-only is set
+var identities *identityTracker
+
+// Command line options
+var (
+	printURL = flag.Bool("print-url", false, "print the WebSocket subscribe URL and exit without connecting")
+
+	preview        = flag.Duration("preview", 0, "apply the filters for this long, report how many events matched with a few samples, and exit")
+	previewSamples = flag.Int("preview-samples", 5, "matching events shown by --preview")
+
+	benchSinksFlag = flag.Bool("bench-sinks", false, "write synthetic events through each configured sink, print throughput and latency, and exit without connecting")
+	benchEvents    = flag.Int("bench-events", 100000, "synthetic events written to each sink by --bench-sinks")
+
+	extractPath = flag.String("extract", "", "print only the value at this path of each event, e.g. commit.record.text")
+
+	strict = flag.Bool("strict", false, "exit non-zero on the first decode error instead of logging and continuing")
+
+	userAgent = flag.String("user-agent", "bluesky-firehose/"+version, "User-Agent sent with the WebSocket handshake")
+
+	statsInterval = flag.Duration("stats-interval", time.Second, "how often to print stats")
+	emaAlpha      = flag.Float64("ema-alpha", 0.1, "smoothing factor (0-1] for the posts-per-hour estimate; higher reacts faster")
+	useDashboard  = flag.Bool("dashboard", false, "show a live dashboard on stderr instead of scrolling stats")
+
+	reportLatencyFlag   = flag.Bool("latency", false, "print delivery latency percentiles (receive time minus time_us) every stats interval")
+	reportBandwidthFlag = flag.Bool("bandwidth", false, "print message sizes and bandwidth by collection every stats interval")
+
+	schemaSamplesPath     = flag.String("schema-samples", "", "append a few raw messages per collection each interval to this file, before filtering")
+	schemaSamplesN        = flag.Int("schema-samples-n", 3, "raw messages sampled per collection each --schema-samples-interval")
+	schemaSamplesInterval = flag.Duration("schema-samples-interval", time.Hour, "how often each collection's --schema-samples quota resets")
+	schemaSamplesMaxSize  = flag.String("schema-samples-max-size", "10MB", "size at which the --schema-samples file is rotated to <file>.1")
+
+	didActivity        = flag.Bool("did-activity", false, "print the events-per-DID distribution of the busiest collections every stats interval")
+	didActivityMaxDIDs = flag.Int("did-activity-max-dids", 10000, "maximum DIDs sampled per collection for --did-activity")
+
+	maxMemory = flag.String("max-memory", "", "soft heap cap such as 512MB; above it caches shrink and events are dropped")
+
+	groupWindow = flag.Duration("group-by-did", 0, "buffer events and emit them grouped by DID within this window (0 disables)")
+	groupMax    = flag.Int("group-max", 100, "maximum events in a DID group before it is flushed early")
+
+	coalesceWindow = flag.Duration("coalesce", 0, "hold commits for this window and emit only the last one for each record (0 disables)")
+
+	purgeSignal = flag.Bool("purge-signal", false, "emit a purge signal when an account is deleted")
+
+	rkeyPrefix = flag.String("rkey-prefix", "", "only process commits whose rkey starts with this prefix")
+	rkeyGlob   = flag.String("rkey-glob", "", "only process commits whose rkey matches this glob, e.g. '3k*'")
+
+	unknownRecords = flag.Bool("unknown-records", false, "print a best-effort summary of records from lexicons without a handler")
+
+	verificationsOnly = flag.Bool("verifications-only", false, "only process app.bsky.graph.verification commits")
+
+	requireLangs = flag.Bool("require-langs", false, "drop posts that do not explicitly declare langs")
+
+	identityChangesOnly = flag.Bool("identity-changes-only", false, "suppress identity events that repeat a DID's last seen handle, display name and description")
+	identityCacheSize   = flag.Int("identity-cache-size", 100000, "maximum DIDs remembered for --identity-changes-only")
+
+	useSentiment = flag.Bool("sentiment", false, "print a rough sentiment score for each post")
+
+	updateMode = flag.String("update-mode", updateModeMerge, "handle updates like creates (merge) or with their own handler (separate)")
+
+	updateDiff      = flag.Bool("update-diff", false, "print updates as a JSON merge patch against the previously seen version of the record")
+	updateDiffCache = flag.Int("update-diff-cache", 10000, "maximum records remembered for --update-diff")
+
+	alertPostsPerMin = flag.Int("alert-posts-per-min", 0, "alert when a DID posts more than this many times in a minute (0 disables)")
+	alertMaxDIDs     = flag.Int("alert-max-dids", 100000, "maximum DIDs tracked for post rate alerts")
+	alertCooldown    = flag.Duration("alert-cooldown", 10*time.Minute, "minimum time between alerts for the same DID")
+
+	topTagsN        = flag.Int("top-tags", 0, "print the N most frequent hashtags every stats interval (0 disables)")
+	topTagsCapacity = flag.Int("top-tags-capacity", 10000, "maximum distinct hashtags tracked for --top-tags")
+
+	feedAddr  = flag.String("feed-addr", "", "serve recent posts as an Atom feed at /feed.xml on this address, e.g. :8080")
+	feedSize  = flag.Int("feed-size", 50, "number of recent posts in the feed")
+	feedTitle = flag.String("feed-title", "Bluesky Firehose", "title of the feed")
+
+	parquetPath  = flag.String("parquet", "", "write posts to this Parquet file (requires -tags parquet)")
+	parquetBatch = flag.Int("parquet-batch", 10000, "posts per Parquet row group")
+	parquetDrain = flag.Duration("parquet-drain-timeout", 0, "time the Parquet sink gets to flush on shutdown (0 uses --drain-timeout)")
+
+	influxAddr     = flag.String("influx", "", "send counts as InfluxDB line protocol to host:port (UDP, or tcp://host:port)")
+	influxInterval = flag.Duration("influx-interval", 10*time.Second, "how often counts are written to InfluxDB")
+	influxDrain    = flag.Duration("influx-drain-timeout", 0, "time the InfluxDB sink gets to flush on shutdown (0 uses --drain-timeout)")
+
+	perDIDDir     = flag.String("per-did-files", "", "append each DID's events to <dir>/<did>.ndjson")
+	perDIDMaxOpen = flag.Int("per-did-max-open", 256, "maximum per-DID files kept open at once")
+	perDIDDrain   = flag.Duration("per-did-drain-timeout", 0, "time the per-DID sink gets to flush on shutdown (0 uses --drain-timeout)")
+
+	splitByLang     = flag.String("split-by-lang", "", "append created posts to <dir>/<lang>.ndjson by declared language")
+	splitMulti      = flag.String("split-multi", "each", "where posts with several langs go: each language's file (each) or multi.ndjson (multi)")
+	splitMaxOpen    = flag.Int("split-max-open", 64, "maximum per-language files kept open at once")
+	splitLangsDrain = flag.Duration("split-drain-timeout", 0, "time the per-language sink gets to flush on shutdown (0 uses --drain-timeout)")
+
+	fifoPath   = flag.String("fifo", "", "write events as NDJSON to this named pipe, created if missing (POSIX only)")
+	fifoMode   = flag.String("fifo-mode", "drop", "what to do with events while no reader has the pipe open: drop or buffer")
+	fifoBuffer = flag.Int("fifo-buffer", 10000, "events queued while no reader is attached in --fifo-mode buffer")
+	fifoDrain  = flag.Duration("fifo-drain-timeout", 0, "time the fifo sink gets to close on shutdown (0 uses --drain-timeout)")
+
+	drainTimeout  = flag.Duration("drain-timeout", 10*time.Second, "default time each sink gets to flush and close on shutdown")
+	flushInterval = flag.Duration("flush-interval", time.Second, "how often sinks write out buffered data while running")
+
+	lifecycleLog = flag.String("lifecycle-log", "", "append connection lifecycle events as JSON lines to this file, or - for stderr")
+
+	useSyslog      = flag.Bool("syslog", false, "send output and logs to syslog instead of stdout/stderr")
+	syslogAddr     = flag.String("syslog-addr", "", "remote syslog server as host:port (optionally tcp:// or udp://); local daemon if empty")
+	syslogFacility = flag.String("syslog-facility", "user", "syslog facility, e.g. user, daemon, local0")
+	syslogSeverity = flag.String("syslog-severity", "info", "syslog severity, e.g. info, notice, warning")
+)
+
 // Event represents the main message structure from the firehose
 type Event struct {
 	Did      string    `json:"did"`
@@ -22,6 +184,11 @@ type Event struct {
 	Commit   *Commit   `json:"commit,omitempty"`
 	Identity *Identity `json:"identity,omitempty"`
 	Account  *Account  `json:"account,omitempty"`
+
+	// Raw is the message the event was decoded from
+	Raw json.RawMessage `json:"-"`
+	// Received is when the message was read
+	Received time.Time `json:"-"`
 }
 
 // Commit represents the commit information in an event
@@ -46,18 +213,134 @@ type Identity struct {
 // Account represents account status changes
 type Account struct {
 	Active bool   `json:"active"`
+	Status string `json:"status,omitempty"`
 	Seq    int64  `json:"seq"`
 	Time   string `json:"time"`
 }
 
+// Values accepted by --update-mode
+const (
+	updateModeMerge    = "merge"
+	updateModeSeparate = "separate"
+)
+
+// accountStatusDeleted is the status Jetstream reports for a deleted account
+const accountStatusDeleted = "deleted"
+
+// purgeKind is the kind of the event sinks receive as a purge signal
+const purgeKind = "purge"
+
 // Post represents the structure of a post record
 type Post struct {
 	Type      string    `json:"$type,omitempty"`
 	Text      string    `json:"text"`
+	Langs     []string  `json:"langs,omitempty"`
+	Facets    []Facet   `json:"facets,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Reply     *ReplyRef `json:"reply,omitempty"`
+	Embed     *Embed    `json:"embed,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// StrongRef points at a specific version of a record
+type StrongRef struct {
+	URI string `json:"uri"`
+	CID string `json:"cid"`
+}
+
+// ReplyRef identifies the thread root and direct parent of a reply
+type ReplyRef struct {
+	Root   StrongRef `json:"root"`
+	Parent StrongRef `json:"parent"`
+}
+
+// Embed is the embedded content of a post. Only the type is decoded, which
+// is all that is needed to tell quotes apart.
+type Embed struct {
+	Type string `json:"$type"`
+}
+
+// Embed types that quote another record
+const (
+	embedRecordType          = "app.bsky.embed.record"
+	embedRecordWithMediaType = "app.bsky.embed.recordWithMedia"
+)
+
+// IsQuote reports whether the post embeds another record
+func (p Post) IsQuote() bool {
+	return p.Embed != nil && (p.Embed.Type == embedRecordType || p.Embed.Type == embedRecordWithMediaType)
+}
+
+// Facet annotates a byte range of post text with rich text features
+type Facet struct {
+	Index    FacetIndex     `json:"index"`
+	Features []FacetFeature `json:"features"`
+}
+
+// FacetIndex is the UTF-8 byte range a facet applies to
+type FacetIndex struct {
+	ByteStart int `json:"byteStart"`
+	ByteEnd   int `json:"byteEnd"`
+}
+
+// FacetFeature is a mention, link or hashtag within a facet
+type FacetFeature struct {
+	Type string `json:"$type"`
+	Did  string `json:"did,omitempty"`
+	URI  string `json:"uri,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+// facetTagType is the $type of a hashtag facet feature
+const facetTagType = "app.bsky.richtext.facet#tag"
+
+// Hashtags returns the post's hashtags, from both tag facets and the
+// out-of-text tags field
+func (p Post) Hashtags() []string {
+	var tags []string
+	for _, facet := range p.Facets {
+		for _, feature := range facet.Features {
+			if feature.Type == facetTagType && feature.Tag != "" {
+				tags = append(tags, feature.Tag)
+			}
+		}
+	}
+	for _, tag := range p.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// atURI returns the AT-URI of the record a commit event refers to
+func atURI(event Event) string {
+	return "at://" + event.Did + "/" + event.Commit.Collection + "/" + event.Commit.RKey
+}
+
 func processEvent(event Event) {
+	processMu.Lock()
+	defer processMu.Unlock()
+	processEventLocked(event)
+}
+
+// processEventLocked handles an event with processMu held, reporting
+// whether it passed the filters
+func processEventLocked(event Event) bool {
+	if !eventAllowed(event) {
+		return false
+	}
+
+	if extractSteps != nil {
+		// Extraction replaces the printing, not the signals derived from posts
+		if c := event.Commit; c != nil && c.Collection == "app.bsky.feed.post" && c.Operation == "create" {
+			observePost(event)
+		}
+		printExtract(event)
+		writeSinks(event)
+		return true
+	}
+
 	switch event.Kind {
 	case "commit":
 		if event.Commit != nil {
@@ -72,113 +355,699 @@ func processEvent(event Event) {
 			processAccount(event)
 		}
 	}
+
+	writeSinks(event)
+	// Sinks get the purge signal after the deletion that raised it
+	if purgesAccount(event) {
+		writeSinks(purgeEvent(event))
+	}
+	return true
 }
 
 func processCommit(event Event) {
-	// Only process create operations
-	if event.Commit.Operation == "create" || event.Commit.Operation == "update" {
-		// If it's a post, try to decode the post content
-		if event.Commit.Collection == "app.bsky.feed.post" {
-			var post Post
-			if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
-				log.Printf("Error unmarshaling post: %v", err)
-				return
-			}
-			// Process post data here
-			fmt.Printf("Post Text: %s\n", post.Text)
-			fmt.Printf("Post %sd At: %s\n", event.Commit.Operation, post.CreatedAt)
+	// With --update-diff, updates of every collection only show what changed
+	if updateDiffs != nil {
+		if event.Commit.Operation == "update" {
+			processUpdateDiff(event)
+			return
+		}
+		updateDiffs.Observe(event)
+	}
+
+	// Collections with their own handlers see every operation
+	switch event.Commit.Collection {
+	case chatDeclarationCollection:
+		processChatDeclaration(event)
+		return
+	case verificationCollection:
+		processVerification(event)
+		return
+	}
+	if *unknownRecords && !knownCollections[event.Commit.Collection] {
+		processUnknownRecord(event)
+		return
+	}
+
+	switch event.Commit.Operation {
+	case "create":
+		processCreate(event)
+	case "update":
+		// Updates share the create path unless asked to keep them apart
+		if *updateMode == updateModeSeparate {
+			processUpdate(event)
+		} else {
+			processCreate(event)
 		}
 	}
 }
 
+// processCreate handles created records, and updated ones in merge mode
+func processCreate(event Event) {
+	// If it's a post, try to decode the post content
+	if event.Commit.Collection == "app.bsky.feed.post" {
+		post, ok := observePost(event)
+		if !ok {
+			return
+		}
+		// Process post data here
+		fmt.Fprintf(out, "Post Text: %s\n", post.Text)
+		if score, ok := scoreSentiment(post.Text); ok {
+			fmt.Fprintf(out, "Post Sentiment: %.2f\n", score)
+		}
+		fmt.Fprintf(out, "Post %sd At: %s\n", event.Commit.Operation, post.CreatedAt)
+	}
+}
+
+// observePost decodes a post and, if it was just created, feeds it to the
+// signals derived from posts: rate alerts, post counts, top tags and the
+// feed. It reports false if the post failed to decode.
+func observePost(event Event) (Post, bool) {
+	if postRates != nil && event.Commit.Operation == "create" {
+		postRates.Observe(event.Did)
+	}
+
+	var post Post
+	if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+		recordDecodeError(fmt.Errorf("post %s/%s: %w", event.Did, event.Commit.RKey, err))
+		return post, false
+	}
+	if event.Commit.Operation == "create" {
+		if feed != nil {
+			feed.Add(feedItem{
+				uri:       atURI(event),
+				did:       event.Did,
+				text:      post.Text,
+				createdAt: post.CreatedAt,
+			})
+		}
+		stats.countPost(post)
+		if topTags != nil {
+			topTags.Observe(post.Hashtags())
+		}
+	}
+	return post, true
+}
+
+// processUpdate handles updated records in separate mode
+func processUpdate(event Event) {
+	if event.Commit.Collection == "app.bsky.feed.post" {
+		var post Post
+		if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+			recordDecodeError(fmt.Errorf("post %s/%s: %w", event.Did, event.Commit.RKey, err))
+			return
+		}
+		fmt.Fprintf(out, "\n--- Post Update ---\n")
+		fmt.Fprintf(out, "DID: %s\n", event.Did)
+		fmt.Fprintf(out, "RKey: %s\n", event.Commit.RKey)
+		fmt.Fprintf(out, "Post Text: %s\n", post.Text)
+		if score, ok := scoreSentiment(post.Text); ok {
+			fmt.Fprintf(out, "Post Sentiment: %.2f\n", score)
+		}
+		fmt.Fprintf(out, "Post Created At: %s\n", post.CreatedAt)
+	}
+}
+
 func processIdentity(event Event) {
 	// Process identity updates
-	fmt.Printf("\n--- Identity Update ---\n")
-	fmt.Printf("DID: %s\n", event.Did)
-	fmt.Printf("Handle: %s\n", event.Identity.Handle)
-	fmt.Printf("Display Name: %s\n", event.Identity.DisplayName)
-	fmt.Printf("Description: %s\n", event.Identity.Description)
-	fmt.Printf("Sequence: %d\n", event.Identity.Seq)
-	fmt.Printf("Time: %s\n", event.Identity.Time)
+	fmt.Fprintf(out, "\n--- Identity Update ---\n")
+	fmt.Fprintf(out, "DID: %s\n", event.Did)
+	fmt.Fprintf(out, "Handle: %s\n", event.Identity.Handle)
+	fmt.Fprintf(out, "Display Name: %s\n", event.Identity.DisplayName)
+	fmt.Fprintf(out, "Description: %s\n", event.Identity.Description)
+	fmt.Fprintf(out, "Sequence: %d\n", event.Identity.Seq)
+	fmt.Fprintf(out, "Time: %s\n", event.Identity.Time)
 }
 
 func processAccount(event Event) {
 	// Process account status changes
-	fmt.Printf("\n--- Account Update ---\n")
-	fmt.Printf("DID: %s\n", event.Did)
-	fmt.Printf("Active: %v\n", event.Account.Active)
-	fmt.Printf("Sequence: %d\n", event.Account.Seq)
-	fmt.Printf("Time: %s\n", event.Account.Time)
+	fmt.Fprintf(out, "\n--- Account Update ---\n")
+	fmt.Fprintf(out, "DID: %s\n", event.Did)
+	fmt.Fprintf(out, "Active: %v\n", event.Account.Active)
+	if event.Account.Status != "" {
+		fmt.Fprintf(out, "Status: %s\n", event.Account.Status)
+	}
+	fmt.Fprintf(out, "Sequence: %d\n", event.Account.Seq)
+	fmt.Fprintf(out, "Time: %s\n", event.Account.Time)
+
+	if purgesAccount(event) {
+		processPurge(event)
+	}
 }
 
-func main() {
-	// Connect to websocket
-	c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-	if err != nil {
-		log.Fatal("dial:", err)
+// purgesAccount reports whether an event raises a purge signal
+func purgesAccount(event Event) bool {
+	return *purgeSignal && event.Account != nil && event.Account.Status == accountStatusDeleted
+}
+
+// processPurge emits a signal that every record held for the DID should be
+// deleted. The firehose does not enumerate a deleted account's records, so
+// this is only a signal: acting on it is up to whatever consumes the output.
+func processPurge(event Event) {
+	fmt.Fprintf(out, "\n--- Purge DID ---\n")
+	fmt.Fprintf(out, "DID: %s\n", event.Did)
+	fmt.Fprintf(out, "Sequence: %d\n", event.Account.Seq)
+	fmt.Fprintf(out, "Time: %s\n", event.Account.Time)
+}
+
+// purgeEvent is the purge signal as sinks see it: an event of kind purge
+// carrying the account status that raised it, so a per-DID file or a pipe
+// reader gets a line of its own to act on
+func purgeEvent(event Event) Event {
+	purge := Event{
+		Did:      event.Did,
+		TimeUS:   event.TimeUS,
+		Kind:     purgeKind,
+		Account:  event.Account,
+		Received: event.Received,
 	}
-	defer c.Close()
+	purge.Raw, _ = json.Marshal(purge)
+	return purge
+}
 
-	// Set up channel for graceful shutdown
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+// errSlowConsumer is returned by Run when the server dropped the connection
+// because the consumer could not keep up
+var errSlowConsumer = errors.New("disconnected as a slow consumer")
 
-	// Add counter for messages
-	var messageCount uint64
+// slowConsumer is set when the connection was closed for being too slow
+var slowConsumer atomic.Bool
 
-	// Start a goroutine to print the rate every second
-	ticker := time.NewTicker(time.Second)
-	go func() {
-		var lastCount uint64
-		for range ticker.C {
-			currentCount := atomic.LoadUint64(&messageCount)
-			rate := currentCount - lastCount
-			fmt.Printf("Messages per second: %d\n", rate)
-			lastCount = currentCount
+// strictErr holds the first decode error seen when running with --strict
+var strictErr atomic.Pointer[error]
+
+// recordDecodeError logs a message or record that failed to decode. With
+// --strict the first such error is kept so Run stops and exits non-zero.
+func recordDecodeError(err error) {
+	log.Printf("Error unmarshaling %v", err)
+	if *strict {
+		strictErr.CompareAndSwap(nil, &err)
+	}
+}
+
+// truncate shortens b to at most n bytes for logging
+func truncate(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	return append(b[:n:n], "..."...)
+}
+
+// Run connects to the firehose using dial and consumes events until the
+// connection drops or a signal arrives on interrupt
+func Run(dial Dialer, interrupt <-chan os.Signal) (err error) {
+	// Registered first so sinks close after the connection
+	defer closeSinks()
+
+	// Runs after the grouper and coalescer flush, since events they emit on
+	// shutdown can fail to decode too
+	defer func() {
+		if strictE := strictErr.Load(); err == nil && strictE != nil {
+			err = fmt.Errorf("strict: %w", *strictE)
 		}
 	}()
+
+	header := http.Header(headers).Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("User-Agent") == "" && *userAgent != "" {
+		header.Set("User-Agent", *userAgent)
+	}
+
+	stats.setConn("connecting")
+	emitLifecycle(lifecycleEvent{Event: "connecting", URL: subscribeURL()})
+	c, err := dial(subscribeURL(), header)
+	if err != nil {
+		stats.setConn("disconnected")
+		emitLifecycle(lifecycleEvent{Event: "dial_failed", URL: subscribeURL(), Reason: err.Error()})
+		return fmt.Errorf("dial: %w", err)
+	}
+	stats.setConn("connected")
+	emitLifecycle(lifecycleEvent{Event: "connected", URL: subscribeURL()})
+	defer stats.setConn("disconnected")
+
+	if *topTagsN > 0 {
+		topTags = newTagTracker(*topTagsCapacity)
+		statsReporters = append(statsReporters, reportTopTags(topTags, *topTagsN))
+	}
+	if *useSentiment {
+		sentiment = lexiconScorer{}
+	}
+	if *requireLangs {
+		statsReporters = append(statsReporters, reportDroppedNoLangs)
+	}
+	if *identityChangesOnly {
+		identities = newIdentityTracker(*identityCacheSize)
+		statsReporters = append(statsReporters, reportIdentityChanges(identities))
+	}
+	if *updateDiff {
+		updateDiffs = newRecordCache(*updateDiffCache)
+	}
+	if *alertPostsPerMin > 0 {
+		postRates = newPostRateMonitor(time.Minute, *alertPostsPerMin, *alertMaxDIDs, *alertCooldown)
+	}
+
+	if *reportLatencyFlag {
+		latency = &latencyTracker{}
+		statsReporters = append(statsReporters, reportLatency(latency))
+	}
+	if *reportBandwidthFlag {
+		bandwidth = newBandwidthTracker()
+		statsReporters = append(statsReporters, reportBandwidth(bandwidth, *statsInterval))
+	}
+	if *didActivity {
+		activity = newActivityTracker(*didActivityMaxDIDs)
+		statsReporters = append(statsReporters, reportActivity(activity))
+	}
+	if schemaSamples != nil {
+		defer schemaSamples.Close()
+	}
+	if memGuard != nil {
+		memTicker := clock.NewTicker(time.Second)
+		go memGuard.Run(memTicker)
+		defer memTicker.Stop()
+		statsReporters = append(statsReporters, reportMemory(memGuard))
+	}
+
+	// Optionally route events through the DID grouper and coalescer, or
+	// only count them when previewing
+	handle := processEvent
+	var grouper *didGrouper
+	var coalesce *coalescer
+	var previewEnd <-chan time.Time
+	// Set when the reader is still blocked, usually in a sink write, after
+	// the drain timeout. It may hold the grouper's or processMu's lock, so
+	// the flushes are skipped rather than left to deadlock.
+	var readerStuck bool
+	if previewing != nil {
+		handle = previewing.Observe
+		previewEnd = clock.After(*preview)
+	} else if *groupWindow > 0 {
+		groupOut = &groupOutput{w: out}
+		out = groupOut
+		grouper = newDIDGrouper(*groupWindow, *groupMax, printDIDGroup)
+		stopGrouper := make(chan struct{})
+		go grouper.Run(stopGrouper)
+		defer func() {
+			if !readerStuck {
+				grouper.Flush()
+			}
+		}()
+		defer close(stopGrouper)
+		handle = grouper.Add
+	}
+	if *coalesceWindow > 0 && previewing == nil {
+		coalesce = newCoalescer(*coalesceWindow, handle)
+		stopCoalescer := make(chan struct{})
+		go coalesce.Run(stopCoalescer)
+		// Deferred after the grouper so pending commits flush into it first
+		defer func() {
+			if !readerStuck {
+				coalesce.Flush()
+			}
+		}()
+		defer close(stopCoalescer)
+		handle = coalesce.Add
+		statsReporters = append(statsReporters, reportCoalesced(coalesce))
+	}
+
+	// Flush the sinks regularly, so a quiet file is not left unwritten
+	// until shutdown
+	if len(sinks) > 0 {
+		flushTicker := clock.NewTicker(*flushInterval)
+		stopFlushes := make(chan struct{})
+		flushesDone := make(chan struct{})
+		go func() {
+			defer close(flushesDone)
+			runSinkFlushes(flushTicker, stopFlushes)
+		}()
+		// Stopped before closeSinks so a flush never overlaps a Close. A
+		// stuck reader holds processMu, and may hold up the flusher too.
+		defer func() {
+			flushTicker.Stop()
+			close(stopFlushes)
+			if !readerStuck {
+				<-flushesDone
+			}
+		}()
+	}
+
+	// Start a goroutine to print the rate, or redraw the dashboard, every
+	// stats interval
+	ticker := clock.NewTicker(*statsInterval)
+	if dash != nil {
+		go dash.Run(ticker)
+	} else {
+		go runStats(ticker, *statsInterval)
+	}
 	defer ticker.Stop()
 
 	// Start reading messages
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
+		degraded := false
 		for {
 			_, message, err := c.ReadMessage()
 			if err != nil {
 				log.Println("read:", err)
+				emitLifecycle(disconnectEvent(err))
+				if isSlowConsumerClose(err) {
+					slowConsumer.Store(true)
+					emitLifecycle(lifecycleEvent{Event: "slow_consumer", Reason: "disconnected by the server for falling behind"})
+					log.Println("WARNING: disconnected by the server as a slow consumer; events were not processed fast enough. " +
+						"Filter more events, disable expensive options, or send output to a faster destination.")
+				}
 				return
 			}
 
 			// Increment the message counter
-			atomic.AddUint64(&messageCount, 1)
+			n := stats.messages.Add(1)
+
+			// Shed load while over the memory cap
+			if memGuard != nil {
+				if pressure := memGuard.pressure.Load(); pressure != degraded {
+					degraded = pressure
+					if degraded {
+						shedMemory(grouper, coalesce)
+					} else {
+						restoreMemory()
+					}
+				}
+				if degraded {
+					memGuard.dropped.Add(1)
+					continue
+				}
+			}
 
 			var event Event
 			if err := json.Unmarshal(message, &event); err != nil {
-				log.Printf("Error unmarshaling event: %v", err)
+				if bandwidth != nil {
+					bandwidth.Observe("undecodable", len(message))
+				}
+				recordDecodeError(fmt.Errorf("event in message %d: %w: %s", n, err, truncate(message, 200)))
+				if *strict {
+					return
+				}
 				continue
 			}
+			event.Raw, event.Received = message, clock.Now()
+			lastTimeUS.Store(event.TimeUS)
+			if latency != nil {
+				latency.Observe(event)
+			}
+			if schemaSamples != nil {
+				schemaSamples.Observe(event)
+			}
+			if bandwidth != nil {
+				bandwidth.Observe(bandwidthKey(event), len(message))
+			}
+			if event.Commit != nil {
+				stats.countCommit(event.Commit.Collection)
+				if activity != nil {
+					activity.Observe(event.Commit.Collection, event.Did)
+				}
+			}
 
-			processEvent(event)
+			handle(event)
+			if *strict && strictErr.Load() != nil {
+				return
+			}
+		}
+	}()
+
+	// Runs before the deferred flushes and closeSinks: closing the
+	// connection ends the reader, and waiting for it means nothing else is
+	// writing to the coalescer, grouper or sinks while they drain. A reader
+	// blocked in a sink gets --drain-timeout, so closeSinks still runs.
+	defer func() {
+		c.Close()
+		select {
+		case <-done:
+		case <-clock.After(*drainTimeout):
+			readerStuck = true
+			log.Printf("WARNING: event processing did not stop within %s, skipping the group and coalesce flushes", *drainTimeout)
 		}
 	}()
 
 	// Wait for interrupt signal
 	select {
 	case <-done:
-		return
-	case <-interrupt:
-		log.Println("Received interrupt signal, closing connection...")
-		err := c.WriteMessage(websocket.CloseMessage,
-			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		if err := strictErr.Load(); err != nil {
+			return fmt.Errorf("strict: %w", *err)
+		}
+		if slowConsumer.Load() {
+			return errSlowConsumer
+		}
+		return nil
+	case <-previewEnd:
+		log.Println("Preview finished, closing connection...")
+		emitLifecycle(lifecycleEvent{Event: "shutdown", Reason: "preview finished"})
+		closeConn(c, done)
+		return nil
+	case sig := <-interrupt:
+		log.Printf("Received %s, closing connection...", sig)
+		emitLifecycle(lifecycleEvent{Event: "shutdown", Reason: sig.String()})
+		closeConn(c, done)
+		return nil
+	}
+}
+
+// closeConn sends a close message and gives the reader a second to see the
+// connection close
+func closeConn(c Conn, done <-chan struct{}) {
+	err := c.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		log.Println("write close:", err)
+	}
+	select {
+	case <-done:
+	case <-clock.After(time.Second):
+	}
+}
+
+// setupSinks enables the sinks selected on the command line
+func setupSinks() {
+	if *parquetPath != "" {
+		if *parquetBatch <= 0 {
+			log.Fatalf("invalid --parquet-batch %d: must be positive", *parquetBatch)
+		}
+		sink, err := newParquetSink(*parquetPath, *parquetBatch)
 		if err != nil {
-			log.Println("write close:", err)
+			log.Fatal(err)
 		}
-		select {
-		case <-done:
-		case <-time.After(time.Second):
+		addSink("parquet", sink, *parquetDrain)
+	}
+	if *influxAddr != "" {
+		sink, err := newInfluxSink(*influxAddr, *influxInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addSink("influx", sink, *influxDrain)
+	}
+	if *perDIDDir != "" {
+		if *perDIDMaxOpen <= 0 {
+			log.Fatalf("invalid --per-did-max-open %d: must be positive", *perDIDMaxOpen)
+		}
+		sink, err := newPerDIDSink(*perDIDDir, *perDIDMaxOpen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addSink("per-did", sink, *perDIDDrain)
+	}
+	if *splitByLang != "" {
+		if *splitMaxOpen <= 0 {
+			log.Fatalf("invalid --split-max-open %d: must be positive", *splitMaxOpen)
+		}
+		sink, err := newLangSink(*splitByLang, *splitMulti, *splitMaxOpen)
+		if err != nil {
+			log.Fatal(err)
 		}
+		addSink("split-by-lang", sink, *splitLangsDrain)
+	}
+	if *fifoPath != "" {
+		if *fifoBuffer <= 0 {
+			log.Fatalf("invalid --fifo-buffer %d: must be positive", *fifoBuffer)
+		}
+		sink, err := newFIFOSink(*fifoPath, *fifoMode, *fifoBuffer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addSink("fifo", sink, *fifoDrain)
+	}
+}
+
+// setupSyslog redirects output and logs to syslog. An unknown facility or
+// severity is fatal, but if syslog is unavailable, both fall back to stderr
+// so output is not silently lost.
+func setupSyslog() {
+	if err := validateSyslog(*syslogFacility, *syslogSeverity); err != nil {
+		log.Fatal(err)
+	}
+	w, err := dialSyslog(*syslogAddr, *syslogFacility, *syslogSeverity)
+	if err != nil {
+		log.Printf("syslog unavailable, falling back to stderr: %v", err)
+		out = os.Stderr
 		return
 	}
+	out = w
+	log.SetOutput(w)
+	// syslog timestamps each message itself
+	log.SetFlags(0)
 }
 
+// setupDashboard draws the dashboard on stderr. Event output is dropped
+// while stdout shares the terminal, and logs are shown in the dashboard,
+// since either would scroll it away. Without a terminal the regular stats
+// output is used instead. It returns a function that puts output and logs
+// back once the dashboard is no longer drawn.
+func setupDashboard() (restore func()) {
+	if !isTerminal(os.Stderr) {
+		log.Println("--dashboard needs a terminal on stderr, printing stats instead")
+		return func() {}
+	}
+	dash = &dashboard{w: os.Stderr, interval: *statsInterval}
+	savedOut, savedLog := out, log.Writer()
+	if out == os.Stdout && isTerminal(os.Stdout) {
+		out = io.Discard
+	}
+	if !*useSyslog {
+		dash.logs = &logTail{}
+		log.SetOutput(dash.logs)
+	}
+	return func() {
+		out = savedOut
+		log.SetOutput(savedLog)
+	}
+}
+
+func main() {
+	flag.Var(threads, "thread", "only process posts in the thread with this root post AT-URI (repeatable)")
+	flag.Var(headers, "header", `extra WebSocket handshake header as "Key: Value" (repeatable)`)
+	flag.Parse()
+
+	if *statsInterval <= 0 {
+		log.Fatalf("invalid --stats-interval %s: must be positive", *statsInterval)
+	}
+	if *flushInterval <= 0 {
+		log.Fatalf("invalid --flush-interval %s: must be positive", *flushInterval)
+	}
+	if *extractPath != "" {
+		steps, err := parsePath(*extractPath)
+		if err != nil {
+			log.Fatalf("invalid --extract: %v", err)
+		}
+		extractSteps = steps
+	}
+	if _, err := path.Match(*rkeyGlob, ""); err != nil {
+		log.Fatalf("invalid --rkey-glob %q: %v", *rkeyGlob, err)
+	}
+	if *maxMemory != "" {
+		limit, err := parseByteSize(*maxMemory)
+		if err != nil || limit == 0 {
+			log.Fatalf("invalid --max-memory %q: want a size such as 512MB", *maxMemory)
+		}
+		memGuard = &memoryGuard{limit: limit}
+	}
+	if *emaAlpha <= 0 || *emaAlpha > 1 {
+		log.Fatalf("invalid --ema-alpha %v: must be in (0, 1]", *emaAlpha)
+	}
+	stats.emaAlpha = *emaAlpha
+	if *updateMode != updateModeMerge && *updateMode != updateModeSeparate {
+		log.Fatalf("invalid --update-mode %q: want %s or %s", *updateMode, updateModeMerge, updateModeSeparate)
+	}
+
+	if *preview < 0 || *previewSamples < 0 {
+		log.Fatal("invalid --preview or --preview-samples: must not be negative")
+	}
+
+	if *printURL {
+		fmt.Println(subscribeURL())
+		return
+	}
+
+	if *useSyslog {
+		setupSyslog()
+	}
+
+	if *feedAddr != "" {
+		if *feedSize <= 0 {
+			log.Fatalf("invalid --feed-size %d: must be positive", *feedSize)
+		}
+		feed = newFeedRing(*feedSize)
+		startFeedServer(*feedAddr, feed, *feedTitle)
+	}
+
+	if *topTagsN > 0 && *topTagsCapacity <= 0 {
+		log.Fatalf("invalid --top-tags-capacity %d: must be positive", *topTagsCapacity)
+	}
+	if *identityChangesOnly && *identityCacheSize <= 0 {
+		log.Fatalf("invalid --identity-cache-size %d: must be positive", *identityCacheSize)
+	}
+	if *alertPostsPerMin > 0 && *alertMaxDIDs <= 0 {
+		log.Fatalf("invalid --alert-max-dids %d: must be positive", *alertMaxDIDs)
+	}
+	if *updateDiff && *updateDiffCache <= 0 {
+		log.Fatalf("invalid --update-diff-cache %d: must be positive", *updateDiffCache)
+	}
+	if *didActivity && *didActivityMaxDIDs <= 0 {
+		log.Fatalf("invalid --did-activity-max-dids %d: must be positive", *didActivityMaxDIDs)
+	}
+
+	if *schemaSamplesPath != "" {
+		maxSize, err := parseByteSize(*schemaSamplesMaxSize)
+		if err != nil || maxSize == 0 {
+			log.Fatalf("invalid --schema-samples-max-size %q: want a size such as 10MB", *schemaSamplesMaxSize)
+		}
+		if *schemaSamplesN <= 0 || *schemaSamplesInterval <= 0 {
+			log.Fatal("invalid --schema-samples-n or --schema-samples-interval: must be positive")
+		}
+		schemaSamples, err = newSchemaSampler(*schemaSamplesPath, *schemaSamplesN, *schemaSamplesInterval, int64(maxSize))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// A preview only counts matches, so nothing is written anywhere
+	if *preview > 0 {
+		previewing = newPreviewCounter(*previewSamples)
+	} else {
+		setupSinks()
+	}
+
+	if *benchSinksFlag {
+		if len(sinks) == 0 {
+			log.Fatal("--bench-sinks needs at least one sink, such as --parquet or --per-did-files")
+		}
+		if *benchEvents <= 0 {
+			log.Fatalf("invalid --bench-events %d: must be positive", *benchEvents)
+		}
+		benchSinks(out, *benchEvents)
+		return
+	}
+
+	if *lifecycleLog != "" {
+		if err := setupLifecycleLog(*lifecycleLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Set up channel for graceful shutdown
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	// Set up last, so startup errors are not hidden in the dashboard's logs
+	restoreDashboard := func() {}
+	if *useDashboard {
+		restoreDashboard = setupDashboard()
+	}
+
+	err := Run(DialWebsocket, interrupt)
+	restoreDashboard()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if previewing != nil {
+		previewing.report(out, *preview)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeConn is a Conn fed from a channel. Sending it a close message ends
+// the stream the way a server acknowledging the close would.
+type fakeConn struct {
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{messages: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	select {
+	case m := <-c.messages:
+		return websocket.TextMessage, m, nil
+	case <-c.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure}
+	}
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.CloseMessage {
+		return c.Close()
+	}
+	return errors.New("fakeConn: unexpected write")
+}
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// fakeSink records what reaches it
+type fakeSink struct {
+	events  int
+	kinds   []string
+	closed  bool
+	written chan struct{}
+}
+
+func (s *fakeSink) WriteEvent(event Event) error {
+	if s.events == 0 {
+		close(s.written)
+	}
+	s.events++
+	s.kinds = append(s.kinds, event.Kind)
+	return nil
+}
+
+func (s *fakeSink) Flush() error { return nil }
+
+func (s *fakeSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// blockedSink never returns from WriteEvent, like a pipe whose reader
+// stopped reading, until it is closed
+type blockedSink struct {
+	written chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (s *blockedSink) WriteEvent(event Event) error {
+	close(s.written)
+	<-s.closed
+	return nil
+}
+
+func (s *blockedSink) Flush() error { return nil }
+
+func (s *blockedSink) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+// isolateRun discards output, clears the sinks and quiets the periodic
+// goroutines for a test that calls Run, restoring them and the rest of the package state Run changes when
+// the test ends
+func isolateRun(t *testing.T) {
+	t.Helper()
+	savedOut, savedSinks, savedReporters := out, sinks, statsReporters
+	savedSlow, savedStrict := slowConsumer.Load(), strictErr.Load()
+	savedStats, savedFlush := *statsInterval, *flushInterval
+	t.Cleanup(func() {
+		out, sinks, statsReporters = savedOut, savedSinks, savedReporters
+		slowConsumer.Store(savedSlow)
+		strictErr.Store(savedStrict)
+		*statsInterval, *flushInterval = savedStats, savedFlush
+	})
+	out = io.Discard
+	sinks = nil
+	// The stats goroutine, and the sink flusher if a sink blocks, outlive
+	// Run, so they must not tick during the test
+	*statsInterval, *flushInterval = time.Hour, time.Hour
+}
+
+func TestRunDrainsSinksOnSIGTERM(t *testing.T) {
+	isolateRun(t)
+	sink := &fakeSink{written: make(chan struct{})}
+	addSink("fake", sink, 0)
+
+	conn := newFakeConn()
+	dial := func(string, http.Header) (Conn, error) { return conn, nil }
+	interrupt := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() { result <- Run(dial, interrupt) }()
+
+	conn.messages <- []byte(`{"did":"did:plc:a","time_us":1,"kind":"identity","identity":{"did":"did:plc:a","handle":"a.test","seq":1,"time":"2026-01-01T00:00:00Z"}}`)
+	select {
+	case <-sink.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event never reached the sink")
+	}
+
+	interrupt <- syscall.SIGTERM
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Run returned %v after SIGTERM", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after SIGTERM")
+	}
+	if !sink.closed {
+		t.Error("sink was not closed on SIGTERM")
+	}
+	if sink.events != 1 {
+		t.Errorf("sink got %d events, want 1", sink.events)
+	}
+}
+
+func TestRunClosesSinksWhenASinkBlocks(t *testing.T) {
+	isolateRun(t)
+	savedDrain := *drainTimeout
+	t.Cleanup(func() { *drainTimeout = savedDrain })
+	*drainTimeout = 100 * time.Millisecond
+	sink := &blockedSink{written: make(chan struct{}), closed: make(chan struct{})}
+	addSink("blocked", sink, 0)
+
+	conn := newFakeConn()
+	dial := func(string, http.Header) (Conn, error) { return conn, nil }
+	interrupt := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() { result <- Run(dial, interrupt) }()
+
+	conn.messages <- []byte(`{"did":"did:plc:a","time_us":1,"kind":"identity","identity":{"did":"did:plc:a","handle":"a.test","seq":1,"time":"2026-01-01T00:00:00Z"}}`)
+	select {
+	case <-sink.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event never reached the sink")
+	}
+
+	interrupt <- syscall.SIGTERM
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return while a sink was blocked")
+	}
+	select {
+	case <-sink.closed:
+	default:
+		t.Error("blocked sink was not closed")
+	}
+}
+
+func TestRunFlushesSinksWhileRunning(t *testing.T) {
+	isolateRun(t)
+	*flushInterval = 10 * time.Millisecond
+	dir := t.TempDir()
+	sink, err := newPerDIDSink(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addSink("per-did", sink, 0)
+
+	conn := newFakeConn()
+	dial := func(string, http.Header) (Conn, error) { return conn, nil }
+	interrupt := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() { result <- Run(dial, interrupt) }()
+	defer func() {
+		interrupt <- syscall.SIGTERM
+		<-result
+	}()
+
+	conn.messages <- []byte(`{"did":"did:plc:a","time_us":1,"kind":"identity","identity":{"did":"did:plc:a","handle":"a.test","seq":1,"time":"2026-01-01T00:00:00Z"}}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := os.ReadFile(dir + "/did_plc_a.ndjson"); len(data) > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event was not flushed to its file while running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPurgeSignalReachesSinks(t *testing.T) {
+	isolateRun(t)
+	savedPurge := *purgeSignal
+	t.Cleanup(func() { *purgeSignal = savedPurge })
+	*purgeSignal = true
+	sink := &fakeSink{written: make(chan struct{})}
+	addSink("fake", sink, 0)
+
+	processEvent(Event{Did: "did:plc:a", Kind: "account", Account: &Account{Status: "deactivated"}})
+	processEvent(Event{Did: "did:plc:a", Kind: "account", Account: &Account{Status: accountStatusDeleted, Seq: 7}})
+
+	want := []string{"account", "account", purgeKind}
+	if !reflect.DeepEqual(sink.kinds, want) {
+		t.Fatalf("sink got kinds %v, want %v", sink.kinds, want)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryRecoverRatio is the fraction of the cap heap usage must fall below
+// before normal processing resumes, so the guard does not flap at the cap
+const memoryRecoverRatio = 0.8
+
+// memoryShrinkFactor is how much the in-memory caches shrink under pressure
+const memoryShrinkFactor = 4
+
+// memoryGuard compares heap usage against a soft cap on every tick. Once
+// usage reaches the cap it reports pressure until usage falls back below
+// memoryRecoverRatio of the cap. It only sets a flag: the read loop does
+// the actual shedding, under processMu where the caches it shrinks are
+// shared with event processing.
+type memoryGuard struct {
+	limit    uint64
+	pressure atomic.Bool
+	heap     atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// Run samples heap usage each time ticker fires
+func (g *memoryGuard) Run(ticker Ticker) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	g.heap.Store(m.HeapAlloc)
+	for range ticker.C() {
+		runtime.ReadMemStats(&m)
+		g.heap.Store(m.HeapAlloc)
+
+		switch {
+		case !g.pressure.Load() && m.HeapAlloc >= g.limit:
+			log.Printf("WARNING: heap %s reached --max-memory %s, shrinking caches and dropping events",
+				formatBytes(m.HeapAlloc), formatBytes(g.limit))
+			g.pressure.Store(true)
+		case g.pressure.Load() && float64(m.HeapAlloc) < float64(g.limit)*memoryRecoverRatio:
+			log.Printf("Heap %s back under %s, resuming normal processing (%d events dropped so far)",
+				formatBytes(m.HeapAlloc), formatBytes(g.limit), g.dropped.Load())
+			g.pressure.Store(false)
+		}
+	}
+}
+
+// processMu orders event processing against the memory guard's cache
+// resizes. processEvent is already serial, running on whichever of the
+// reader, coalescer or grouper comes last in the chain, but shedMemory and
+// restoreMemory run on the reader and would race with the other two.
+var processMu sync.Mutex
+
+// memoryShrinker is implemented by sinks holding caches the memory guard
+// can shrink, such as open file handles
+type memoryShrinker interface {
+	shrinkMemory()
+	restoreMemory()
+}
+
+// shedMemory shrinks every in-memory cache and flushes buffered commits and
+// groups. It must run on the read loop.
+func shedMemory(grouper *didGrouper, coalesce *coalescer) {
+	processMu.Lock()
+	if postRates != nil {
+		postRates.dids.Resize(max(1, *alertMaxDIDs/memoryShrinkFactor))
+	}
+	if identities != nil {
+		identities.seen.Resize(max(1, *identityCacheSize/memoryShrinkFactor))
+	}
+	if updateDiffs != nil {
+		updateDiffs.records.Resize(max(1, *updateDiffCache/memoryShrinkFactor))
+	}
+	for _, s := range sinks {
+		if shrinker, ok := s.sink.(memoryShrinker); ok {
+			shrinker.shrinkMemory()
+		}
+	}
+	processMu.Unlock()
+
+	if topTags != nil {
+		topTags.Resize(max(1, *topTagsCapacity/memoryShrinkFactor))
+	}
+	if activity != nil {
+		activity.Resize(max(1, *didActivityMaxDIDs/memoryShrinkFactor))
+	}
+	// Flushing processes events, so it must not hold processMu. The
+	// coalescer goes first since it feeds the grouper.
+	if coalesce != nil {
+		coalesce.Flush()
+	}
+	if grouper != nil {
+		grouper.Flush()
+	}
+	debug.FreeOSMemory()
+}
+
+// restoreMemory returns the caches to their configured sizes. It must run
+// on the read loop.
+func restoreMemory() {
+	processMu.Lock()
+	if postRates != nil {
+		postRates.dids.Resize(*alertMaxDIDs)
+	}
+	if identities != nil {
+		identities.seen.Resize(*identityCacheSize)
+	}
+	if updateDiffs != nil {
+		updateDiffs.records.Resize(*updateDiffCache)
+	}
+	for _, s := range sinks {
+		if shrinker, ok := s.sink.(memoryShrinker); ok {
+			shrinker.restoreMemory()
+		}
+	}
+	processMu.Unlock()
+
+	if topTags != nil {
+		topTags.Resize(*topTagsCapacity)
+	}
+	if activity != nil {
+		activity.Resize(*didActivityMaxDIDs)
+	}
+}
+
+// reportMemory returns a stats reporter printing heap usage against the cap
+func reportMemory(g *memoryGuard) func(w io.Writer) {
+	return func(w io.Writer) {
+		state := "ok"
+		if g.pressure.Load() {
+			state = "degraded"
+		}
+		fmt.Fprintf(w, "Memory: heap %s of %s (%s), events dropped: %d\n",
+			formatBytes(g.heap.Load()), formatBytes(g.limit), state, g.dropped.Load())
+	}
+}
+
+// parseByteSize parses sizes such as 512MB or 2GiB. Units are binary, so
+// 1MB is 1024*1024 bytes; a bare number is bytes.
+func parseByteSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		scale  uint64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	scale := uint64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper, scale = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix)), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseUint(upper, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * scale, nil
+}
+
+// formatBytes renders a byte count in MiB
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// previewCounter tallies how many events pass the filters during
+// --preview and keeps the first few that do
+type previewCounter struct {
+	mu          sync.Mutex
+	maxSamples  int
+	total       uint64
+	matched     uint64
+	collections map[string]uint64
+	samples     [][]byte
+}
+
+func newPreviewCounter(maxSamples int) *previewCounter {
+	return &previewCounter{maxSamples: maxSamples, collections: make(map[string]uint64)}
+}
+
+// Observe runs an event through the same filters as processEvent, without
+// printing it or writing it to sinks
+func (p *previewCounter) Observe(event Event) {
+	allowed := eventAllowed(event)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total++
+	if !allowed {
+		return
+	}
+	p.matched++
+	p.collections[bandwidthKey(event)]++
+	if len(p.samples) < p.maxSamples {
+		p.samples = append(p.samples, truncate(event.Raw, 300))
+	}
+}
+
+// report prints the match rate, the collections that matched and the
+// sampled events
+func (p *previewCounter) report(w io.Writer, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(w, "\n--- Preview (%s) ---\n", d)
+	fmt.Fprintf(w, "Matched: %d of %d events (%.1f%%)\n", p.matched, p.total, percent(p.matched, p.total))
+	for _, c := range topN(p.collections, 5) {
+		fmt.Fprintf(w, "  %s: %d (%.1f%% of matches)\n", c.name, c.count, percent(c.count, p.matched))
+	}
+	if len(p.samples) > 0 {
+		fmt.Fprintln(w, "Samples:")
+	}
+	for _, sample := range p.samples {
+		fmt.Fprintf(w, "  %s\n", sample)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// postRateMonitor tracks each DID's posting rate over a sliding window and
+// raises an alert when a DID posts more than threshold times within it.
+// Memory is bounded by evicting the least recently active DID once maxDIDs
+// are tracked, and each DID alerts at most once per cooldown.
+type postRateMonitor struct {
+	window    time.Duration
+	threshold int
+	cooldown  time.Duration
+	dids      *lruCache[string, *didRate]
+	alert     func(did string, threshold int, window time.Duration)
+}
+
+// didRate is the recent posting history of a single DID
+type didRate struct {
+	posts     []time.Time
+	lastAlert time.Time
+}
+
+func newPostRateMonitor(window time.Duration, threshold, maxDIDs int, cooldown time.Duration) *postRateMonitor {
+	return &postRateMonitor{
+		window:    window,
+		threshold: threshold,
+		cooldown:  cooldown,
+		dids:      newLRU[string, *didRate](maxDIDs),
+		alert:     logPostRateAlert,
+	}
+}
+
+// Observe records a post by did and alerts if its rate exceeds the threshold
+func (m *postRateMonitor) Observe(did string) {
+	now := clock.Now()
+
+	rate, ok := m.dids.Get(did)
+	if !ok {
+		rate = &didRate{}
+		m.dids.Put(did, rate)
+	}
+
+	// Drop posts that have slid out of the window. Only threshold+1 posts
+	// are ever needed to detect a breach, which keeps each entry small.
+	cutoff := now.Add(-m.window)
+	kept := rate.posts[:0]
+	for _, t := range rate.posts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	rate.posts = append(kept, now)
+	if len(rate.posts) > m.threshold+1 {
+		rate.posts = rate.posts[len(rate.posts)-m.threshold-1:]
+	}
+
+	if len(rate.posts) > m.threshold && now.Sub(rate.lastAlert) >= m.cooldown {
+		rate.lastAlert = now
+		m.alert(did, m.threshold, m.window)
+	}
+}
+
+// logPostRateAlert logs a breach. Only threshold+1 posts are kept per DID,
+// so the alert names the threshold rather than a count.
+func logPostRateAlert(did string, threshold int, window time.Duration) {
+	log.Printf("ALERT: %s posted more than %d times within %s, possible bot or compromised account", did, threshold, window)
+}
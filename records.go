@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chatDeclarationCollection holds an account's chat (DM) settings
+const chatDeclarationCollection = "chat.bsky.actor.declaration"
+
+// ChatDeclaration represents a chat.bsky.actor.declaration record
+type ChatDeclaration struct {
+	Type          string `json:"$type,omitempty"`
+	AllowIncoming string `json:"allowIncoming"`
+}
+
+// parseChatDeclaration decodes a declaration leniently, since the chat
+// lexicon is still new: a mistyped allowIncoming is rendered as raw JSON
+// rather than rejecting the record
+func parseChatDeclaration(record json.RawMessage) (ChatDeclaration, error) {
+	var decl ChatDeclaration
+	if err := json.Unmarshal(record, &decl); err == nil {
+		return decl, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return decl, err
+	}
+	json.Unmarshal(fields["$type"], &decl.Type)
+	if raw, ok := fields["allowIncoming"]; ok {
+		decl.AllowIncoming = string(raw)
+	}
+	return decl, nil
+}
+
+// processChatDeclaration prints who an account accepts direct messages
+// from. Declarations use the fixed rkey "self", so each account has one.
+func processChatDeclaration(event Event) {
+	fmt.Fprintf(out, "\n--- Chat Declaration ---\n")
+	fmt.Fprintf(out, "DID: %s\n", event.Did)
+	fmt.Fprintf(out, "Operation: %s\n", event.Commit.Operation)
+
+	if event.Commit.Operation == "delete" {
+		return
+	}
+	decl, err := parseChatDeclaration(event.Commit.Record)
+	if err != nil {
+		recordDecodeError(fmt.Errorf("chat declaration %s: %w", event.Did, err))
+		return
+	}
+	allow := decl.AllowIncoming
+	if allow == "" {
+		allow = "(unset)"
+	}
+	fmt.Fprintf(out, "Allow Incoming: %s\n", allow)
+}
+
+// verificationCollection holds verifications an account has issued. The
+// lexicon is new and the NSID may still change; update it here if so.
+const verificationCollection = "app.bsky.graph.verification"
+
+// Verification represents an app.bsky.graph.verification record: the
+// repo's account vouches that Subject is the account with this handle and
+// display name
+type Verification struct {
+	Type        string    `json:"$type,omitempty"`
+	Subject     string    `json:"subject"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"displayName"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// parseVerification decodes a verification leniently: fields with an
+// unexpected type are left empty rather than rejecting the record
+func parseVerification(record json.RawMessage) (Verification, error) {
+	var v Verification
+	if err := json.Unmarshal(record, &v); err == nil {
+		return v, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return v, err
+	}
+	json.Unmarshal(fields["$type"], &v.Type)
+	json.Unmarshal(fields["subject"], &v.Subject)
+	json.Unmarshal(fields["handle"], &v.Handle)
+	json.Unmarshal(fields["displayName"], &v.DisplayName)
+	json.Unmarshal(fields["createdAt"], &v.CreatedAt)
+	return v, nil
+}
+
+// processVerification prints a verification as a verifier -> subject
+// edge. Deletes only carry the record key, so the subject of a revoked
+// verification is not known.
+func processVerification(event Event) {
+	fmt.Fprintf(out, "\n--- Verification ---\n")
+	fmt.Fprintf(out, "Operation: %s\n", event.Commit.Operation)
+	fmt.Fprintf(out, "URI: %s\n", atURI(event))
+
+	if event.Commit.Operation == "delete" {
+		fmt.Fprintf(out, "Edge: %s -> (revoked)\n", event.Did)
+		return
+	}
+	v, err := parseVerification(event.Commit.Record)
+	if err != nil {
+		recordDecodeError(fmt.Errorf("verification %s: %w", atURI(event), err))
+		return
+	}
+	subject := v.Subject
+	if subject == "" {
+		subject = "(unknown)"
+	}
+	fmt.Fprintf(out, "Edge: %s -> %s\n", event.Did, subject)
+	fmt.Fprintf(out, "Handle: %s\n", v.Handle)
+	fmt.Fprintf(out, "Display Name: %s\n", v.DisplayName)
+	if !v.CreatedAt.IsZero() {
+		fmt.Fprintf(out, "Verified At: %s\n", v.CreatedAt)
+	}
+}
+
+// knownCollections are the Bluesky lexicons this consumer knows about,
+// whether or not it prints them. Commits to any other collection go to
+// processUnknownRecord when --unknown-records is set.
+var knownCollections = map[string]bool{
+	"app.bsky.actor.profile":            true,
+	"app.bsky.actor.status":             true,
+	"app.bsky.feed.generator":           true,
+	"app.bsky.feed.like":                true,
+	"app.bsky.feed.post":                true,
+	"app.bsky.feed.postgate":            true,
+	"app.bsky.feed.repost":              true,
+	"app.bsky.feed.threadgate":          true,
+	"app.bsky.graph.block":              true,
+	"app.bsky.graph.follow":             true,
+	"app.bsky.graph.list":               true,
+	"app.bsky.graph.listblock":          true,
+	"app.bsky.graph.listitem":           true,
+	"app.bsky.graph.starterpack":        true,
+	"app.bsky.labeler.service":          true,
+	"app.bsky.notification.declaration": true,
+	chatDeclarationCollection:           true,
+	verificationCollection:              true,
+}
+
+// unknownFieldDepth is how deep into nested objects the unknown record
+// handler looks for text and subject fields
+const unknownFieldDepth = 3
+
+// UnknownRecord is the best-effort summary of a record from a lexicon
+// without its own handler, built from fields most lexicons share
+type UnknownRecord struct {
+	Type      string
+	CreatedAt string
+	Text      string
+	Subject   string
+	Fields    []string
+}
+
+// parseUnknownRecord picks out $type, createdAt, the first text field and
+// the first subject field (a string, or an object's uri or did), looking
+// into nested objects for the latter two. Fields lists the record's
+// top-level keys.
+func parseUnknownRecord(record json.RawMessage) (UnknownRecord, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return UnknownRecord{}, err
+	}
+	r := UnknownRecord{}
+	r.Type, _ = fields["$type"].(string)
+	r.CreatedAt, _ = fields["createdAt"].(string)
+	if text, ok := findField(fields, "text", unknownFieldDepth).(string); ok {
+		r.Text = text
+	}
+	switch subject := findField(fields, "subject", unknownFieldDepth).(type) {
+	case string:
+		r.Subject = subject
+	case map[string]any:
+		if uri, ok := subject["uri"].(string); ok {
+			r.Subject = uri
+		} else if did, ok := subject["did"].(string); ok {
+			r.Subject = did
+		}
+	}
+	for key := range fields {
+		r.Fields = append(r.Fields, key)
+	}
+	sort.Strings(r.Fields)
+	return r, nil
+}
+
+// findField returns the value of the first key found in obj, searching
+// breadth first through nested objects up to depth levels
+func findField(obj map[string]any, key string, depth int) any {
+	level := []map[string]any{obj}
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var next []map[string]any
+		for _, o := range level {
+			if v, ok := o[key]; ok {
+				return v
+			}
+			for _, k := range sortedKeys(o) {
+				if nested, ok := o[k].(map[string]any); ok {
+					next = append(next, nested)
+				}
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// processUnknownRecord prints what can be guessed about a record from an
+// unsupported lexicon. The fields are heuristics, not a schema, and may be
+// missing or mean something else in a given lexicon.
+func processUnknownRecord(event Event) {
+	fmt.Fprintf(out, "\n--- Unknown Record (best effort) ---\n")
+	fmt.Fprintf(out, "Collection: %s\n", event.Commit.Collection)
+	fmt.Fprintf(out, "Operation: %s\n", event.Commit.Operation)
+	fmt.Fprintf(out, "URI: %s\n", atURI(event))
+
+	if event.Commit.Operation == "delete" {
+		return
+	}
+	r, err := parseUnknownRecord(event.Commit.Record)
+	if err != nil {
+		recordDecodeError(fmt.Errorf("record %s: %w", atURI(event), err))
+		return
+	}
+	for _, field := range []struct{ name, value string }{
+		{"Type", r.Type},
+		{"Created At", r.CreatedAt},
+		{"Text", r.Text},
+		{"Subject", r.Subject},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(out, "%s: %s\n", field.name, field.value)
+		}
+	}
+	fmt.Fprintf(out, "Fields: %s\n", strings.Join(r.Fields, ", "))
+}
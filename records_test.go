@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// loadEvent decodes a Jetstream event from a file in testdata
+func loadEvent(t *testing.T, name string) Event {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("decode %s: %v", name, err)
+	}
+	return event
+}
+
+func TestParseVerification(t *testing.T) {
+	event := loadEvent(t, "verification.json")
+	v, err := parseVerification(event.Commit.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Verification{
+		Type:        verificationCollection,
+		Subject:     "did:plc:subject",
+		Handle:      "subject.bsky.social",
+		DisplayName: "Subject",
+		CreatedAt:   time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	}
+	if v != want {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+}
+
+func TestParseVerificationLenient(t *testing.T) {
+	// displayName is a number and createdAt is not a timestamp, so the
+	// strict decode fails and both are left empty
+	event := loadEvent(t, "verification_mistyped.json")
+	v, err := parseVerification(event.Commit.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Verification{
+		Type:    verificationCollection,
+		Subject: "did:plc:subject",
+		Handle:  "subject.bsky.social",
+	}
+	if v != want {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+}
+
+func TestParseVerificationNotObject(t *testing.T) {
+	if _, err := parseVerification(json.RawMessage(`"not a record"`)); err == nil {
+		t.Error("expected an error for a record that is not an object")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// schemaSampler writes the first few raw messages of each collection in
+// every interval to a file, before any filtering, so the shape of what the
+// firehose sends can be diffed over time. The file is rotated to path.1
+// once it reaches maxSize. It is only used from the reader goroutine.
+type schemaSampler struct {
+	path        string
+	perInterval int
+	interval    time.Duration
+	maxSize     int64
+
+	file        *os.File
+	size        int64
+	windowStart time.Time
+	taken       map[string]int
+}
+
+func newSchemaSampler(path string, perInterval int, interval time.Duration, maxSize int64) (*schemaSampler, error) {
+	s := &schemaSampler{
+		path:        path,
+		perInterval: perInterval,
+		interval:    interval,
+		maxSize:     maxSize,
+		windowStart: clock.Now(),
+		taken:       make(map[string]int),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *schemaSampler) open() error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("schema samples: open %s: %w", s.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("schema samples: stat %s: %w", s.path, err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// Observe writes the message if its collection has not used up its samples
+// for the current interval
+func (s *schemaSampler) Observe(event Event) {
+	if s.file == nil {
+		return
+	}
+	if now := clock.Now(); now.Sub(s.windowStart) >= s.interval {
+		s.windowStart = now
+		clear(s.taken)
+	}
+	key := bandwidthKey(event)
+	if s.taken[key] >= s.perInterval {
+		return
+	}
+	s.taken[key]++
+
+	if s.size+int64(len(event.Raw))+1 > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			log.Printf("Error rotating schema samples, sampling stopped: %v", err)
+			return
+		}
+	}
+	n, err := s.file.Write(append(event.Raw[:len(event.Raw):len(event.Raw)], '\n'))
+	s.size += int64(n)
+	if err != nil {
+		log.Printf("Error writing schema sample: %v", err)
+	}
+}
+
+// rotate moves the current file to path.1, replacing any older one, and
+// starts a new file
+func (s *schemaSampler) rotate() error {
+	s.file.Close()
+	s.file = nil
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("schema samples: %w", err)
+	}
+	return s.open()
+}
+
+func (s *schemaSampler) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
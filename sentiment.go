@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minSentimentWords is the shortest text, in words, that gets scored
+const minSentimentWords = 3
+
+// Scorer assigns a sentiment score to post text, from -1 (negative) to 1
+// (positive). Implement it to plug in a real model.
+type Scorer interface {
+	Score(text string) float64
+}
+
+// lexiconScorer is the built-in Scorer. It counts words from small positive
+// and negative word lists, flipping a word that directly follows a negation,
+// and returns (positive - negative) / (positive + negative). It knows
+// nothing about sarcasm, emoji or languages other than English, so treat
+// its scores as a rough heuristic.
+type lexiconScorer struct{}
+
+var positiveWords = wordSet("good great love loved lovely excellent amazing awesome happy glad " +
+	"nice best beautiful wonderful fantastic fun enjoy enjoyed cool brilliant perfect " +
+	"thanks thank congrats congratulations excited proud yay win won favorite like liked")
+
+var negativeWords = wordSet("bad terrible awful hate hated horrible worst sad angry annoying " +
+	"ugly boring broken disappointed disappointing fail failed wrong poor sick tired " +
+	"upset stupid ugh lost lose scary afraid worried dislike disgusting pain")
+
+var negationWords = wordSet("not no never don't doesn't didn't isn't wasn't can't won't")
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+func (lexiconScorer) Score(text string) float64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	var positive, negative int
+	for i, w := range words {
+		isPositive, isNegative := positiveWords[w], negativeWords[w]
+		if i > 0 && negationWords[words[i-1]] {
+			isPositive, isNegative = isNegative, isPositive
+		}
+		if isPositive {
+			positive++
+		}
+		if isNegative {
+			negative++
+		}
+	}
+	if positive+negative == 0 {
+		return 0
+	}
+	return float64(positive-negative) / float64(positive+negative)
+}
+
+// scoreSentiment scores text with the configured scorer, reporting false
+// when scoring is off or the text is too short to judge
+func scoreSentiment(text string) (float64, bool) {
+	if sentiment == nil || len(strings.Fields(text)) < minSentimentWords {
+		return 0, false
+	}
+	return sentiment.Score(text), true
+}
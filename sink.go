@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Sink receives events alongside the printed output. Sinks are written to
+// from the single event processing path, so implementations need not be
+// safe for concurrent use. Flush pushes buffered data to the destination
+// and is called every --flush-interval. Only Close is called on shutdown,
+// so it must flush before releasing it.
+type Sink interface {
+	WriteEvent(event Event) error
+	Flush() error
+	Close() error
+}
+
+// sinkEntry is a configured sink with the name used in logs and the time it
+// is given to drain on shutdown
+type sinkEntry struct {
+	name  string
+	sink  Sink
+	drain time.Duration
+}
+
+// sinks are the configured sinks, in the order they were enabled
+var sinks []sinkEntry
+
+// addSink enables a sink. A drain timeout of zero means --drain-timeout.
+func addSink(name string, sink Sink, drain time.Duration) {
+	sinks = append(sinks, sinkEntry{name: name, sink: sink, drain: drain})
+}
+
+// writeSinks hands an event to every configured sink. A failing sink is
+// logged and does not stop the others.
+func writeSinks(event Event) {
+	for _, s := range sinks {
+		if err := s.sink.WriteEvent(event); err != nil {
+			log.Printf("Error writing to %s sink: %v", s.name, err)
+		}
+	}
+}
+
+// runSinkFlushes flushes every sink each time ticker fires until stop is
+// closed. It holds processMu so a flush never overlaps a write.
+func runSinkFlushes(ticker Ticker, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			processMu.Lock()
+			for _, s := range sinks {
+				if err := s.sink.Flush(); err != nil {
+					log.Printf("Error flushing %s sink: %v", s.name, err)
+				}
+			}
+			processMu.Unlock()
+		}
+	}
+}
+
+// closeSinks flushes and closes every configured sink concurrently, giving
+// each its own drain timeout so a slow sink cannot hold up a fast one. A
+// sink that times out is reported and abandoned; its Close keeps running
+// until the process exits.
+func closeSinks() {
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		timeout := s.drain
+		if timeout <= 0 {
+			timeout = *drainTimeout
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			closed := make(chan error, 1)
+			go func() { closed <- s.sink.Close() }()
+
+			select {
+			case err := <-closed:
+				if err != nil {
+					log.Printf("Error closing %s sink: %v", s.name, err)
+				}
+			case <-clock.After(timeout):
+				log.Printf("WARNING: %s sink did not drain within %s, data may be lost", s.name, timeout)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,154 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoRetryInterval limits how often the sink tries to open the pipe while
+// no reader is attached
+const fifoRetryInterval = 100 * time.Millisecond
+
+// fifoSink writes each event's raw JSON as a line to a named pipe. The
+// pipe is opened without blocking, which fails until a reader has it open;
+// while no reader is attached events are dropped, or queued up to buffer
+// events (dropping the oldest). When the reader goes away the write fails
+// with EPIPE and the sink goes back to waiting for one.
+type fifoSink struct {
+	path    string
+	created bool
+	buffer  int
+
+	file      *os.File
+	lastTry   time.Time
+	queue     [][]byte
+	dropped   uint64
+	connected bool
+}
+
+func newFIFOSink(path, mode string, buffer int) (Sink, error) {
+	s := &fifoSink{path: path}
+	switch mode {
+	case "drop":
+	case "buffer":
+		s.buffer = buffer
+	default:
+		return nil, fmt.Errorf("fifo: unknown mode %q: want drop or buffer", mode)
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := syscall.Mkfifo(path, 0o644); err != nil {
+			return nil, fmt.Errorf("fifo: create %s: %w", path, err)
+		}
+		s.created = true
+	case err != nil:
+		return nil, fmt.Errorf("fifo: %w", err)
+	case info.Mode()&fs.ModeNamedPipe == 0:
+		return nil, fmt.Errorf("fifo: %s exists and is not a named pipe", path)
+	}
+	return s, nil
+}
+
+func (s *fifoSink) WriteEvent(event Event) error {
+	line := append(event.Raw[:len(event.Raw):len(event.Raw)], '\n')
+	if s.file == nil && !s.open() {
+		s.hold(line)
+		return nil
+	}
+
+	for len(s.queue) > 0 {
+		if !s.write(s.queue[0]) {
+			s.hold(line)
+			return nil
+		}
+		s.queue = s.queue[1:]
+	}
+	if !s.write(line) {
+		s.hold(line)
+	}
+	return nil
+}
+
+// open tries to open the pipe for writing, failing quietly if no reader
+// has it open yet
+func (s *fifoSink) open() bool {
+	now := clock.Now()
+	if now.Sub(s.lastTry) < fifoRetryInterval {
+		return false
+	}
+	s.lastTry = now
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if !errors.Is(err, syscall.ENXIO) {
+			log.Printf("Error opening fifo %s: %v", s.path, err)
+		}
+		return false
+	}
+	s.file = file
+	if s.dropped > 0 {
+		log.Printf("fifo %s: reader attached, %d events dropped while detached", s.path, s.dropped)
+	} else if s.connected {
+		log.Printf("fifo %s: reader attached", s.path)
+	}
+	s.connected, s.dropped = true, 0
+	return true
+}
+
+// write writes one line, and on failure closes the pipe so the next event
+// waits for a new reader
+func (s *fifoSink) write(line []byte) bool {
+	if _, err := s.file.Write(line); err != nil {
+		if !errors.Is(err, syscall.EPIPE) {
+			log.Printf("Error writing to fifo %s: %v", s.path, err)
+		} else {
+			log.Printf("fifo %s: reader detached", s.path)
+		}
+		s.file.Close()
+		s.file = nil
+		return false
+	}
+	return true
+}
+
+// hold queues a line while no reader is attached, or drops it
+func (s *fifoSink) hold(line []byte) {
+	if s.buffer == 0 {
+		s.dropped++
+		return
+	}
+	if len(s.queue) >= s.buffer {
+		s.queue = s.queue[1:]
+		s.dropped++
+	}
+	s.queue = append(s.queue, line)
+}
+
+// Flush is a no-op: every line is written as it arrives
+func (s *fifoSink) Flush() error {
+	return nil
+}
+
+// Close closes the pipe, so the reader sees end of file, and removes it if
+// the sink created it. Queued events are discarded.
+func (s *fifoSink) Close() error {
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+	}
+	if s.created {
+		if rmErr := os.Remove(s.path); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
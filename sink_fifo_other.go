@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func newFIFOSink(path, mode string, buffer int) (Sink, error) {
+	return nil, errors.New("fifo: named pipes are not supported on this platform")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// influxMaxDatagram keeps UDP payloads under a typical MTU so line protocol
+// batches are not fragmented or dropped
+const influxMaxDatagram = 1400
+
+// influxSink aggregates event counts and periodically writes them as
+// InfluxDB line protocol to a UDP or TCP listener. Counts cover the time
+// since the previous write; see the README for the schema.
+type influxSink struct {
+	network  string
+	addr     string
+	conn     net.Conn
+	interval time.Duration
+	last     time.Time
+
+	posts   int
+	replies int
+	quotes  int
+	langs   map[string]int
+	events  map[string]int
+	bytes   map[string]int
+	sizes   map[string]int
+	latency latencyWindow
+}
+
+// newInfluxSink sends to addr (host:port over UDP, or prefixed with tcp://
+// or udp://) every interval
+func newInfluxSink(addr string, interval time.Duration) (Sink, error) {
+	network := "udp"
+	if scheme, rest, found := strings.Cut(addr, "://"); found {
+		network, addr = scheme, rest
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("influx: unsupported network %q, want udp or tcp", network)
+	}
+
+	s := &influxSink{
+		network:  network,
+		addr:     addr,
+		interval: interval,
+		last:     clock.Now(),
+		langs:    make(map[string]int),
+		events:   make(map[string]int),
+		bytes:    make(map[string]int),
+		sizes:    make(map[string]int),
+	}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *influxSink) dial() error {
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("influx: dial %s %s: %w", s.network, s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *influxSink) WriteEvent(event Event) error {
+	s.events[event.Kind]++
+	key := bandwidthKey(event)
+	s.bytes[key] += len(event.Raw)
+	s.sizes[key]++
+	s.latency.Observe(event)
+
+	if event.Commit != nil && event.Commit.Collection == "app.bsky.feed.post" && event.Commit.Operation == "create" {
+		var post Post
+		if err := json.Unmarshal(event.Commit.Record, &post); err == nil {
+			s.posts++
+			if post.Reply != nil {
+				s.replies++
+			}
+			if post.IsQuote() {
+				s.quotes++
+			}
+			if len(post.Langs) == 0 {
+				s.langs["none"]++
+			}
+			for _, lang := range post.Langs {
+				s.langs[strings.ToLower(lang)]++
+			}
+		}
+	}
+
+	return s.Flush()
+}
+
+// Flush writes the counts once the interval has passed since the last
+// write, so periodic flushes keep points on schedule while no events arrive
+func (s *influxSink) Flush() error {
+	if clock.Now().Sub(s.last) < s.interval {
+		return nil
+	}
+	return s.report()
+}
+
+// report writes the counts gathered since the last write and resets them
+func (s *influxSink) report() error {
+	now := clock.Now()
+	s.last = now
+	ts := now.UnixNano()
+
+	var lines []string
+	postFields := fmt.Sprintf("count=%di,replies=%di,quotes=%di", s.posts, s.replies, s.quotes)
+	if topLevel := s.posts - s.replies; topLevel > 0 {
+		postFields += fmt.Sprintf(",reply_ratio=%.4f", float64(s.replies)/float64(topLevel))
+	}
+	if s.posts > 0 {
+		postFields += fmt.Sprintf(",quote_ratio=%.4f", float64(s.quotes)/float64(s.posts))
+	}
+	if perHour, ok := stats.estimatedPostsPerHour(); ok {
+		postFields += fmt.Sprintf(",per_hour=%.1f", perHour)
+	}
+	lines = append(lines, fmt.Sprintf("bluesky_posts %s %d", postFields, ts))
+	for _, kind := range sortedKeys(s.events) {
+		lines = append(lines, fmt.Sprintf("bluesky_events,kind=%s count=%di %d", escapeInfluxTag(kind), s.events[kind], ts))
+	}
+	for _, lang := range sortedKeys(s.langs) {
+		lines = append(lines, fmt.Sprintf("bluesky_post_langs,lang=%s count=%di %d", escapeInfluxTag(lang), s.langs[lang], ts))
+	}
+
+	for _, key := range sortedKeys(s.bytes) {
+		lines = append(lines, fmt.Sprintf("bluesky_bytes,collection=%s bytes=%di,messages=%di %d",
+			escapeInfluxTag(key), s.bytes[key], s.sizes[key], ts))
+	}
+
+	if l := s.latency; l.events > 0 {
+		lines = append(lines, fmt.Sprintf("bluesky_latency p50_ms=%.1f,p90_ms=%.1f,p99_ms=%.1f,max_ms=%.1f,ahead=%di %d",
+			millis(l.percentile(50)), millis(l.percentile(90)), millis(l.percentile(99)), millis(l.max), l.ahead, ts))
+	}
+
+	s.posts, s.replies, s.quotes = 0, 0, 0
+	s.latency = latencyWindow{}
+	clear(s.bytes)
+	clear(s.sizes)
+	clear(s.langs)
+	clear(s.events)
+
+	return s.send(lines)
+}
+
+// send writes lines in as few writes as possible, splitting UDP batches so
+// each datagram stays under influxMaxDatagram
+func (s *influxSink) send(lines []string) error {
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+
+	var batch strings.Builder
+	write := func() error {
+		if batch.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write([]byte(batch.String()))
+		batch.Reset()
+		if err != nil {
+			// Redial on the next flush rather than failing every write
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("influx: write: %w", err)
+		}
+		return nil
+	}
+
+	for _, line := range lines {
+		if s.network == "udp" && batch.Len() > 0 && batch.Len()+len(line)+1 > influxMaxDatagram {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		batch.WriteString(line)
+		batch.WriteByte('\n')
+	}
+	return write()
+}
+
+func (s *influxSink) Close() error {
+	err := s.report()
+	if s.conn != nil {
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// escapeInfluxTag escapes the characters line protocol reserves in tag values
+func escapeInfluxTag(v string) string {
+	if v == "" {
+		return "none"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(v)
+}
+
+// millis converts d to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// langSink appends each created post's raw JSON to a file per declared
+// language in a directory: <lang>.ndjson, unknown.ndjson for posts without
+// langs, and for posts with several either each language's file or
+// multi.ndjson
+type langSink struct {
+	files   *appendFiles
+	toMulti bool
+}
+
+func newLangSink(dir, multi string, maxOpen int) (Sink, error) {
+	if multi != "each" && multi != "multi" {
+		return nil, fmt.Errorf("split-by-lang: unknown multi-language mode %q: want each or multi", multi)
+	}
+	files, err := newAppendFiles(dir, maxOpen)
+	if err != nil {
+		return nil, fmt.Errorf("split-by-lang: %w", err)
+	}
+	return &langSink{files: files, toMulti: multi == "multi"}, nil
+}
+
+func (s *langSink) WriteEvent(event Event) error {
+	if event.Commit == nil || event.Commit.Operation != "create" || event.Commit.Collection != "app.bsky.feed.post" {
+		return nil
+	}
+	var post Post
+	if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+		return nil
+	}
+
+	for _, name := range langFiles(post.Langs, s.toMulti) {
+		if err := s.files.WriteLine(name, event.Raw); err != nil {
+			return fmt.Errorf("split-by-lang: %w", err)
+		}
+	}
+	return nil
+}
+
+// Files for posts without a language and, with --split-multi multi, posts
+// with several. langFileName keeps lang tags from writing to them.
+const (
+	unknownLangFile = "unknown"
+	multiLangFile   = "multi"
+)
+
+// langFiles returns the files a post with langs goes to. Tags are
+// lowercased, since they are case-insensitive and clients differ, and
+// repeated tags are written once.
+func langFiles(langs []string, toMulti bool) []string {
+	var names []string
+	for _, lang := range langs {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" {
+			continue
+		}
+		if name := langFileName(lang); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	switch {
+	case len(names) == 0:
+		return []string{unknownLangFile}
+	case len(names) > 1 && toMulti:
+		return []string{multiLangFile}
+	}
+	return names
+}
+
+// langFileName returns the file for a lang tag. langs is free text, so a
+// tag naming a reserved file, or one starting with the '_' used to escape
+// it, gets a leading '_': "multi" goes to _multi.ndjson and "_multi" to
+// __multi.ndjson, and no tag lands in multi.ndjson or unknown.ndjson.
+func langFileName(lang string) string {
+	name := safeFilename(lang)
+	if name == unknownLangFile || name == multiLangFile || strings.HasPrefix(name, "_") {
+		return "_" + name
+	}
+	return name
+}
+
+func (s *langSink) Flush() error {
+	if err := s.files.Flush(); err != nil {
+		return fmt.Errorf("split-by-lang: %w", err)
+	}
+	return nil
+}
+
+func (s *langSink) Close() error {
+	if err := s.files.Close(); err != nil {
+		return fmt.Errorf("split-by-lang: %w", err)
+	}
+	return nil
+}
+
+func (s *langSink) shrinkMemory()  { s.files.shrinkMemory() }
+func (s *langSink) restoreMemory() { s.files.restoreMemory() }
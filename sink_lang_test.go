@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLangFiles(t *testing.T) {
+	tests := []struct {
+		langs   []string
+		toMulti bool
+		want    []string
+	}{
+		{nil, false, []string{"unknown"}},
+		{[]string{" ", ""}, false, []string{"unknown"}},
+		{[]string{"en"}, false, []string{"en"}},
+		{[]string{"EN-US", "en-us"}, false, []string{"en-us"}},
+		{[]string{"en", "ja"}, false, []string{"en", "ja"}},
+		{[]string{"en", "ja"}, true, []string{"multi"}},
+		{[]string{"en", "EN"}, true, []string{"en"}},
+		// Tags that would otherwise land in the reserved files
+		{[]string{"multi"}, false, []string{"_multi"}},
+		{[]string{"Unknown"}, false, []string{"_unknown"}},
+		{[]string{"_multi"}, false, []string{"__multi"}},
+		{[]string{"*unknown"}, false, []string{"__unknown"}},
+		{[]string{"multi", "unknown"}, false, []string{"_multi", "_unknown"}},
+	}
+	for _, tt := range tests {
+		if got := langFiles(tt.langs, tt.toMulti); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("langFiles(%q, %v) = %q, want %q", tt.langs, tt.toMulti, got, tt.want)
+		}
+	}
+}
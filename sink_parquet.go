@@ -0,0 +1,106 @@
+//go:build parquet
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetPost is the Parquet schema for a post row
+type parquetPost struct {
+	Did       string    `parquet:"did"`
+	RKey      string    `parquet:"rkey"`
+	Text      string    `parquet:"text"`
+	Langs     []string  `parquet:"langs,list"`
+	CreatedAt time.Time `parquet:"created_at,timestamp(microsecond)"`
+	TimeUS    int64     `parquet:"time_us"`
+}
+
+// parquetSink writes created and updated posts to a Parquet file, one row
+// group per batch
+type parquetSink struct {
+	file   *os.File
+	writer *parquet.GenericWriter[parquetPost]
+	batch  []parquetPost
+	size   int
+}
+
+func newParquetSink(path string, batchSize int) (Sink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create parquet file: %w", err)
+	}
+	return &parquetSink{
+		file:   file,
+		writer: parquet.NewGenericWriter[parquetPost](file),
+		batch:  make([]parquetPost, 0, batchSize),
+		size:   batchSize,
+	}, nil
+}
+
+func (s *parquetSink) WriteEvent(event Event) error {
+	if event.Commit == nil || event.Commit.Collection != "app.bsky.feed.post" {
+		return nil
+	}
+	if event.Commit.Operation != "create" && event.Commit.Operation != "update" {
+		return nil
+	}
+
+	var post Post
+	if err := json.Unmarshal(event.Commit.Record, &post); err != nil {
+		return fmt.Errorf("parquet: unmarshal post: %w", err)
+	}
+	s.batch = append(s.batch, parquetPost{
+		Did:       event.Did,
+		RKey:      event.Commit.RKey,
+		Text:      post.Text,
+		Langs:     post.Langs,
+		CreatedAt: post.CreatedAt,
+		TimeUS:    event.TimeUS,
+	})
+
+	if len(s.batch) >= s.size {
+		return s.writeBatch()
+	}
+	return nil
+}
+
+// Flush is a no-op: the file cannot be read before Close writes its
+// footer, so writing row groups early would only make them smaller
+func (s *parquetSink) Flush() error {
+	return nil
+}
+
+// writeBatch writes the pending batch as a row group
+func (s *parquetSink) writeBatch() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	if _, err := s.writer.Write(s.batch); err != nil {
+		return fmt.Errorf("parquet: write rows: %w", err)
+	}
+	s.batch = s.batch[:0]
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("parquet: flush row group: %w", err)
+	}
+	return nil
+}
+
+// Close writes the final row group and the file footer. A Parquet file is
+// unreadable without its footer, so this must run on shutdown.
+func (s *parquetSink) Close() error {
+	if err := s.writeBatch(); err != nil {
+		s.file.Close()
+		return err
+	}
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return fmt.Errorf("parquet: write footer: %w", err)
+	}
+	return s.file.Close()
+}
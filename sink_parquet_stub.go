@@ -0,0 +1,11 @@
+//go:build !parquet
+
+package main
+
+import (
+	"errors"
+)
+
+func newParquetSink(path string, batchSize int) (Sink, error) {
+	return nil, errors.New("parquet support not built in, rebuild with -tags parquet")
+}
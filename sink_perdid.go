@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+// perDIDSink appends each event's raw JSON to <dir>/<did>.ndjson, keeping
+// only the most recently written files open
+type perDIDSink struct {
+	files *appendFiles
+}
+
+func newPerDIDSink(dir string, maxOpen int) (Sink, error) {
+	files, err := newAppendFiles(dir, maxOpen)
+	if err != nil {
+		return nil, fmt.Errorf("per-did: %w", err)
+	}
+	return &perDIDSink{files: files}, nil
+}
+
+func (s *perDIDSink) WriteEvent(event Event) error {
+	if err := s.files.WriteLine(event.Did, event.Raw); err != nil {
+		return fmt.Errorf("per-did: %w", err)
+	}
+	return nil
+}
+
+func (s *perDIDSink) Flush() error {
+	if err := s.files.Flush(); err != nil {
+		return fmt.Errorf("per-did: %w", err)
+	}
+	return nil
+}
+
+func (s *perDIDSink) Close() error {
+	if err := s.files.Close(); err != nil {
+		return fmt.Errorf("per-did: %w", err)
+	}
+	return nil
+}
+
+func (s *perDIDSink) shrinkMemory()  { s.files.shrinkMemory() }
+func (s *perDIDSink) restoreMemory() { s.files.restoreMemory() }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counters are the running totals shared by the periodic stats output and
+// the dashboard. Messages are counted as they are read, collections for
+// every commit received and languages for every post created.
+type counters struct {
+	messages atomic.Uint64
+	posts    atomic.Uint64
+	replies  atomic.Uint64
+	quotes   atomic.Uint64
+
+	mu          sync.Mutex
+	collections map[string]uint64
+	langs       map[string]uint64
+
+	conn      atomic.Value // string
+	connSince atomic.Value // time.Time
+
+	// Sampling state, only touched by the stats goroutine
+	lastMessages uint64
+	lastPosts    uint64
+	lastReplies  uint64
+	lastQuotes   uint64
+	emaAlpha     float64
+	emaSampled   bool
+	postsPerSec  float64
+	postsPerHour atomic.Value // float64, the smoothed estimate
+}
+
+// stats holds the totals for the current run
+var stats = newCounters()
+
+func newCounters() *counters {
+	c := &counters{
+		collections: make(map[string]uint64),
+		langs:       make(map[string]uint64),
+		emaAlpha:    0.1,
+	}
+	c.setConn("starting")
+	return c
+}
+
+// setConn records the connection status and when it last changed
+func (c *counters) setConn(status string) {
+	c.conn.Store(status)
+	c.connSince.Store(clock.Now())
+}
+
+// connStatus returns the connection status and how long it has held
+func (c *counters) connStatus() (string, time.Duration) {
+	return c.conn.Load().(string), clock.Now().Sub(c.connSince.Load().(time.Time))
+}
+
+func (c *counters) countCommit(collection string) {
+	c.mu.Lock()
+	c.collections[collection]++
+	c.mu.Unlock()
+}
+
+// countPost counts a created post, whether it is a reply or a quote, and
+// its declared languages
+func (c *counters) countPost(post Post) {
+	c.posts.Add(1)
+	if post.Reply != nil {
+		c.replies.Add(1)
+	}
+	if post.IsQuote() {
+		c.quotes.Add(1)
+	}
+	c.mu.Lock()
+	if len(post.Langs) == 0 {
+		c.langs["none"]++
+	}
+	for _, lang := range post.Langs {
+		c.langs[lang]++
+	}
+	c.mu.Unlock()
+}
+
+// statsSample is what happened over one stats interval
+type statsSample struct {
+	messagesPerSec float64
+	posts          uint64
+	replies        uint64
+	quotes         uint64
+}
+
+// replyRatio returns replies per top-level post over the interval
+func (s statsSample) replyRatio() (float64, bool) {
+	topLevel := s.posts - s.replies
+	if topLevel == 0 {
+		return 0, false
+	}
+	return float64(s.replies) / float64(topLevel), true
+}
+
+// quoteRatio returns the share of posts over the interval that quote another
+func (s statsSample) quoteRatio() (float64, bool) {
+	if s.posts == 0 {
+		return 0, false
+	}
+	return float64(s.quotes) / float64(s.posts), true
+}
+
+// sample is called once per stats interval. It returns what changed over
+// the interval and folds the post rate into the posts-per-hour exponential
+// moving average.
+func (c *counters) sample(interval time.Duration) statsSample {
+	messages, posts := c.messages.Load(), c.posts.Load()
+	replies, quotes := c.replies.Load(), c.quotes.Load()
+	sample := statsSample{
+		messagesPerSec: float64(messages-c.lastMessages) / interval.Seconds(),
+		posts:          posts - c.lastPosts,
+		replies:        replies - c.lastReplies,
+		quotes:         quotes - c.lastQuotes,
+	}
+	postsPerSec := float64(sample.posts) / interval.Seconds()
+	c.lastMessages, c.lastPosts = messages, posts
+	c.lastReplies, c.lastQuotes = replies, quotes
+
+	if c.emaSampled {
+		c.postsPerSec = c.emaAlpha*postsPerSec + (1-c.emaAlpha)*c.postsPerSec
+	} else {
+		c.postsPerSec, c.emaSampled = postsPerSec, true
+	}
+	c.postsPerHour.Store(c.postsPerSec * 3600)
+	return sample
+}
+
+// estimatedPostsPerHour returns the smoothed posts-per-hour estimate and
+// whether at least one interval has been sampled
+func (c *counters) estimatedPostsPerHour() (float64, bool) {
+	v, ok := c.postsPerHour.Load().(float64)
+	return v, ok
+}
+
+// totalCommits returns the number of commits counted across collections
+func (c *counters) totalCommits() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total uint64
+	for _, count := range c.collections {
+		total += count
+	}
+	return total
+}
+
+// ranked is a name and its count, as returned by the top helpers
+type ranked struct {
+	name  string
+	count uint64
+}
+
+// topCollections returns up to n collections by descending count
+func (c *counters) topCollections(n int) []ranked {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return topN(c.collections, n)
+}
+
+// topLangs returns up to n languages by descending count
+func (c *counters) topLangs(n int) []ranked {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return topN(c.langs, n)
+}
+
+func topN(m map[string]uint64, n int) []ranked {
+	all := make([]ranked, 0, len(m))
+	for name, count := range m {
+		all = append(all, ranked{name, count})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].count != all[j].count {
+			return all[i].count > all[j].count
+		}
+		return all[i].name < all[j].name
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// statsReporters add lines to the periodic stats output after the message
+// rate. They run on the stats goroutine, so any state they read must be
+// synchronized with the event processing path.
+var statsReporters []func(w io.Writer)
+
+// runStats prints the message rate, followed by every stats reporter, each
+// time ticker fires
+func runStats(ticker Ticker, interval time.Duration) {
+	for range ticker.C() {
+		sample := stats.sample(interval)
+		fmt.Fprintf(out, "Messages per second: %.0f\n", sample.messagesPerSec)
+		if perHour, ok := stats.estimatedPostsPerHour(); ok {
+			fmt.Fprintf(out, "Posts per hour (estimated): %.0f\n", perHour)
+		}
+		if line := sampleRatios(sample); line != "" {
+			fmt.Fprintln(out, line)
+		}
+
+		for _, report := range statsReporters {
+			report(out)
+		}
+	}
+}
+
+// sampleRatios formats the interval's reply and quote ratios, or returns
+// an empty string if no posts were seen
+func sampleRatios(sample statsSample) string {
+	var parts []string
+	if ratio, ok := sample.replyRatio(); ok {
+		parts = append(parts, fmt.Sprintf("replies per top-level post: %.2f", ratio))
+	}
+	if ratio, ok := sample.quoteRatio(); ok {
+		parts = append(parts, fmt.Sprintf("quotes per post: %.2f", ratio))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Post mix: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,82 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+var syslogSeverities = map[string]syslog.Priority{
+	"emerg":   syslog.LOG_EMERG,
+	"alert":   syslog.LOG_ALERT,
+	"crit":    syslog.LOG_CRIT,
+	"err":     syslog.LOG_ERR,
+	"warning": syslog.LOG_WARNING,
+	"notice":  syslog.LOG_NOTICE,
+	"info":    syslog.LOG_INFO,
+	"debug":   syslog.LOG_DEBUG,
+}
+
+// syslogPriority looks up the priority for a facility and severity name
+func syslogPriority(facility, severity string) (syslog.Priority, error) {
+	fac, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return 0, fmt.Errorf("invalid --syslog-facility %q: unknown facility", facility)
+	}
+	sev, ok := syslogSeverities[strings.ToLower(severity)]
+	if !ok {
+		return 0, fmt.Errorf("invalid --syslog-severity %q: unknown severity", severity)
+	}
+	return fac | sev, nil
+}
+
+// validateSyslog reports a facility or severity name syslog does not know
+func validateSyslog(facility, severity string) error {
+	_, err := syslogPriority(facility, severity)
+	return err
+}
+
+// dialSyslog connects to the local syslog daemon, or to addr when set.
+// addr is host:port over UDP, or may be prefixed with tcp:// or udp://.
+func dialSyslog(addr, facility, severity string) (io.Writer, error) {
+	priority, err := syslogPriority(facility, severity)
+	if err != nil {
+		return nil, err
+	}
+
+	network := ""
+	if addr != "" {
+		network = "udp"
+		if scheme, rest, found := strings.Cut(addr, "://"); found {
+			network, addr = scheme, rest
+		}
+	}
+
+	return syslog.Dial(network, addr, priority, "bluesky-firehose")
+}
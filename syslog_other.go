@@ -0,0 +1,18 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// validateSyslog accepts any names, since syslog is unavailable here and
+// setupSyslog falls back to stderr
+func validateSyslog(facility, severity string) error {
+	return nil
+}
+
+func dialSyslog(addr, facility, severity string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// recordCache remembers the latest version of recently created or updated
+// records by AT-URI, so --update-diff can show what an update changed. It
+// is only used from the event processing path.
+type recordCache struct {
+	records *lruCache[string, json.RawMessage]
+}
+
+func newRecordCache(size int) *recordCache {
+	return &recordCache{records: newLRU[string, json.RawMessage](size)}
+}
+
+// Observe caches created records and forgets deleted ones
+func (r *recordCache) Observe(event Event) {
+	switch event.Commit.Operation {
+	case "create":
+		r.records.Put(atURI(event), bytes.Clone(event.Commit.Record))
+	case "delete":
+		r.records.Remove(atURI(event))
+	}
+}
+
+// processUpdateDiff prints an update as a JSON merge patch against the
+// cached previous version, or the whole record if none is cached
+func processUpdateDiff(event Event) {
+	uri := atURI(event)
+	fmt.Fprintf(out, "\n--- Record Update ---\n")
+	fmt.Fprintf(out, "URI: %s\n", uri)
+
+	previous, cached := updateDiffs.records.Get(uri)
+	updateDiffs.records.Put(uri, bytes.Clone(event.Commit.Record))
+	if !cached {
+		fmt.Fprintf(out, "Record (no earlier version cached): %s\n", event.Commit.Record)
+		return
+	}
+	patch, err := mergePatch(previous, event.Commit.Record)
+	if err != nil {
+		recordDecodeError(fmt.Errorf("update %s: %w", uri, err))
+		return
+	}
+	fmt.Fprintf(out, "Patch: %s\n", patch)
+}
+
+// mergePatch returns the RFC 7386 JSON merge patch that turns the record
+// before into after: changed and added fields with their new values,
+// removed fields as null, and nested objects diffed recursively. Arrays are
+// replaced whole.
+func mergePatch(before, after json.RawMessage) ([]byte, error) {
+	old, err := decodeObject(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := decodeObject(after)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(diffObjects(old, updated))
+}
+
+// decodeObject decodes a record keeping numbers as json.Number, so
+// integers too large for a float64 are still compared exactly
+func decodeObject(record json.RawMessage) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(record))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func diffObjects(old, updated map[string]any) map[string]any {
+	patch := make(map[string]any)
+	for key := range old {
+		if _, ok := updated[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range updated {
+		previous, ok := old[key]
+		if ok && reflect.DeepEqual(previous, value) {
+			continue
+		}
+		oldObject, wasObject := previous.(map[string]any)
+		newObject, isObject := value.(map[string]any)
+		if ok && wasObject && isObject {
+			patch[key] = diffObjects(oldObject, newObject)
+			continue
+		}
+		patch[key] = value
+	}
+	return patch
+}
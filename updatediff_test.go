@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffObjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{
+			name:   "unchanged",
+			before: `{"text":"hi","langs":["en"]}`,
+			after:  `{"langs":["en"],"text":"hi"}`,
+			want:   `{}`,
+		},
+		{
+			name:   "changed and added",
+			before: `{"text":"hi"}`,
+			after:  `{"text":"hello","langs":["en"]}`,
+			want:   `{"langs":["en"],"text":"hello"}`,
+		},
+		{
+			name:   "removed",
+			before: `{"text":"hi","langs":["en"]}`,
+			after:  `{"text":"hi"}`,
+			want:   `{"langs":null}`,
+		},
+		{
+			name:   "nested",
+			before: `{"embed":{"uri":"at://a","cid":"x","alt":"old"}}`,
+			after:  `{"embed":{"uri":"at://a","cid":"y"}}`,
+			want:   `{"embed":{"alt":null,"cid":"y"}}`,
+		},
+		{
+			name:   "object replaced by a string",
+			before: `{"embed":{"uri":"at://a"}}`,
+			after:  `{"embed":"none"}`,
+			want:   `{"embed":"none"}`,
+		},
+		{
+			name:   "array replaced whole",
+			before: `{"langs":["en","de"]}`,
+			after:  `{"langs":["en","fr"]}`,
+			want:   `{"langs":["en","fr"]}`,
+		},
+		{
+			name:   "large integer",
+			before: `{"count":12345678901234567}`,
+			after:  `{"count":12345678901234568}`,
+			want:   `{"count":12345678901234568}`,
+		},
+		{
+			name:   "same number",
+			before: `{"count":12345678901234567,"ratio":0.5}`,
+			after:  `{"count":12345678901234567,"ratio":0.5}`,
+			want:   `{}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := decodeObject(json.RawMessage(tt.before))
+			if err != nil {
+				t.Fatal(err)
+			}
+			updated, err := decodeObject(json.RawMessage(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			patch, err := json.Marshal(diffObjects(old, updated))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(patch) != tt.want {
+				t.Errorf("got %s, want %s", patch, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := mergePatch(json.RawMessage(`{"text":"hi"}`), json.RawMessage(`[1]`)); err == nil {
+		t.Error("expected an error for a record that is not an object")
+	}
+}